@@ -0,0 +1,66 @@
+package thoughts
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// adminSyncPath is where a sync is triggered by hand.
+const adminSyncPath = "/admin/sync"
+
+// syncResult is what a manual sync reports.
+type syncResult struct {
+	Hash      string `json:"hash"`
+	Documents int    `json:"documents"`
+}
+
+// serveAdminSync syncs the standby buffer and swaps to it, the same as a
+// tick of the sync loop, and reports what is now served. It takes the
+// admin token, or basic auth when there is no token, and is not served
+// when neither is set, since every call spends API quota.
+func (s *Site) serveAdminSync(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case s.adminToken != "":
+		if !s.adminTokenOK(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="thoughts"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	case s.basicAuthUser != "":
+		// Checked again in case the site is served without its middleware.
+		if !s.basicAuthOK(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="thoughts", charset="UTF-8"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := s.swapRepos(r.Context()); err != nil {
+		s.logger.Printf("manual sync failed: %v\n", err)
+		http.Error(w, "sync failed", http.StatusBadGateway)
+		return
+	}
+
+	info := s.status()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(syncResult{Hash: info.Hash, Documents: info.Documents}); err != nil {
+		s.logger.Printf("failed to write sync result: %v\n", err)
+	}
+}
+
+// adminTokenOK reports whether r carries the admin token as a bearer token.
+func (s *Site) adminTokenOK(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
@@ -0,0 +1,161 @@
+package thoughts
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestServeAdminSync(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	fp := &fakeProvider{hash: "abc123", files: newTestFS(map[string]string{"README.md": "# First"})}
+	a, b := newRepo(logger, fp, RenderOptions{}), newRepo(logger, fp, RenderOptions{})
+	if err := a.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s := &Site{
+		title: "test", logger: logger, tpl: mustParseWrapper(t),
+		versionA: a, versionB: b,
+		basicAuthUser: "admin", basicAuthPass: "secret",
+	}
+	s.active.Store(a)
+	h := s.handler()
+
+	fp.hash = "def456"
+	fp.files = newTestFS(map[string]string{"README.md": "# Second", "about.md": "# About"})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/sync", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the endpoint to need auth, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/sync", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected GET to be refused, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/sync", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	var got syncResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != (syncResult{Hash: "def456", Documents: 2}) {
+		t.Errorf("unexpected result %+v", got)
+	}
+	if s.activeRepo() != b || s.activeRepo().Index().Title() != "Second" {
+		t.Error("expected the synced standby to become active")
+	}
+}
+
+func TestServeAdminSyncWhileServing(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	fp := &fakeProvider{hash: "abc123", files: newTestFS(map[string]string{"README.md": "# First"})}
+	a, b := newRepo(logger, fp, RenderOptions{}), newRepo(logger, fp, RenderOptions{})
+	if err := a.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s := &Site{title: "test", logger: logger, tpl: mustParseWrapper(t), versionA: a, versionB: b, adminToken: "token"}
+	s.active.Store(a)
+	h := s.handler()
+
+	// Run with -race: the swap happens on a request goroutine while other
+	// requests read the active repo.
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+				if rec.Code != http.StatusOK {
+					t.Errorf("expected 200 during the swap, got %d", rec.Code)
+				}
+			}
+		}()
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/sync", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	wg.Wait()
+
+	if s.activeRepo() != b {
+		t.Error("expected the synced standby to become active")
+	}
+}
+
+func TestServeAdminSyncToken(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	fp := &fakeProvider{hash: "abc123", files: newTestFS(map[string]string{"README.md": "# First"})}
+	newSite := func(s *Site) http.Handler {
+		a, b := newRepo(logger, fp, RenderOptions{}), newRepo(logger, fp, RenderOptions{})
+		if err := a.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		s.title, s.logger, s.tpl, s.versionA, s.versionB = "test", logger, mustParseWrapper(t), a, b
+		s.active.Store(a)
+		return s.handler()
+	}
+	post := func(h http.Handler, auth string) int {
+		req := httptest.NewRequest(http.MethodPost, "/admin/sync", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	open := newSite(&Site{})
+	if code := post(open, ""); code != http.StatusNotFound {
+		t.Errorf("expected 404 without an admin token or basic auth, got %d", code)
+	}
+
+	h := newSite(&Site{adminToken: "token"})
+	for _, tt := range []struct {
+		auth string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer nope", http.StatusUnauthorized},
+		{"Basic dG9rZW46", http.StatusUnauthorized},
+		{"Bearer token", http.StatusOK},
+	} {
+		if code := post(h, tt.auth); code != tt.want {
+			t.Errorf("Authorization %q: expected %d, got %d", tt.auth, tt.want, code)
+		}
+	}
+
+	// The token is enough on a site behind basic auth, whose other pages
+	// still need the password.
+	h = newSite(&Site{adminToken: "token", basicAuthUser: "admin", basicAuthPass: "secret"})
+	if code := post(h, "Bearer token"); code != http.StatusOK {
+		t.Errorf("expected the token to pass basic auth, got %d", code)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected other pages to need basic auth, got %d", rec.Code)
+	}
+}
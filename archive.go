@@ -0,0 +1,51 @@
+package thoughts
+
+import (
+	"net/http"
+	"time"
+)
+
+// archiveYear groups dated documents by the year and month they were
+// written, newest first.
+type archiveYear struct {
+	Year   int
+	Months []archiveMonth
+}
+
+type archiveMonth struct {
+	Month     time.Month
+	Documents []*document
+}
+
+// buildArchive groups documents, which must be sorted oldest first, into
+// years and months in reverse chronological order.
+func buildArchive(dated []*document) []archiveYear {
+	var years []archiveYear
+	for i := len(dated) - 1; i >= 0; i-- {
+		d := dated[i]
+		year, month := d.Date().Year(), d.Date().Month()
+
+		if len(years) == 0 || years[len(years)-1].Year != year {
+			years = append(years, archiveYear{Year: year})
+		}
+		y := &years[len(years)-1]
+
+		if len(y.Months) == 0 || y.Months[len(y.Months)-1].Month != month {
+			y.Months = append(y.Months, archiveMonth{Month: month})
+		}
+		m := &y.Months[len(y.Months)-1]
+		m.Documents = append(m.Documents, d)
+	}
+	return years
+}
+
+func (s *Site) serveArchive(w http.ResponseWriter, r *http.Request) {
+	s.servePage(w, r, s.archivePage(), "archive", s.activeRepo().Archive())
+}
+
+func (s *Site) archivePage() pageData {
+	data := s.newPage(nil)
+	data.PageTitle = "Archive"
+	data.Breadcrumbs = []pageLink{{Name: s.siteTitle(), URL: s.basePath + "/"}, {Name: "archive"}}
+	return data
+}
@@ -0,0 +1,64 @@
+package thoughts
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRepoArchive(t *testing.T) {
+	r := newTestRepo(t, map[string]string{
+		"README.md":              "# Index",
+		"about.md":               "# About",
+		"thoughts/2023-11-02.md": "# November",
+		"thoughts/2024-01-20.md": "# Late January",
+		"thoughts/2024-01-05.md": "# Early January",
+		"thoughts/2024-03-15.md": "# March",
+	})
+
+	archive := r.Archive()
+	if len(archive) != 2 || archive[0].Year != 2024 || archive[1].Year != 2023 {
+		t.Fatalf("expected 2024 then 2023, got %+v", archive)
+	}
+
+	months := archive[0].Months
+	if len(months) != 2 || months[0].Month.String() != "March" || months[1].Month.String() != "January" {
+		t.Fatalf("expected March then January, got %+v", months)
+	}
+
+	january := months[1].Documents
+	if len(january) != 2 || january[0].Title() != "Late January" || january[1].Title() != "Early January" {
+		t.Fatalf("expected newest January note first, got %v, %v", january[0].Title(), january[1].Title())
+	}
+}
+
+func TestSiteServeArchive(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md":              "# Hello",
+		"thoughts/2024-03-15.md": "# March note",
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/archive", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{"2024", "March", `<a href="/thoughts/2024-03-15">March note</a>`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in output, got %s", want, body)
+		}
+	}
+
+	s = newTestSite(t, map[string]string{
+		"README.md": "# Hello",
+	})
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "No entries yet.") {
+		t.Fatalf("expected a friendly empty archive, got %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,112 @@
+package thoughts
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// dirListing is the generated page of a folder without a landing page.
+type dirListing struct {
+	Name      string
+	Dirs      []pageLink
+	Documents []*document
+}
+
+// Listing lists the documents and folders directly inside dir, reporting
+// whether dir holds any documents at all. A folder with a landing page
+// links to it.
+func (r *repo) Listing(dir string) (dirListing, bool) {
+	listing := dirListing{Name: path.Base(dir)}
+	dirs := make(map[string]bool)
+	prefix := dir + "/"
+
+	for p, d := range r.documents {
+		rest, ok := strings.CutPrefix(p, prefix)
+		if !ok {
+			continue
+		}
+		if sub, _, nested := strings.Cut(rest, "/"); nested {
+			dirs[sub] = true
+		} else if d.isLandingPage() {
+			dirs[rest] = true
+		} else {
+			listing.Documents = append(listing.Documents, d)
+		}
+	}
+	if len(dirs) == 0 && len(listing.Documents) == 0 {
+		return dirListing{}, false
+	}
+
+	for sub := range dirs {
+		link := pageLink{Name: sub, URL: r.opts.basePath + "/" + prefix + sub + "/"}
+		if d, ok := r.documents[prefix+sub]; ok && d.isLandingPage() {
+			link.URL = d.URL()
+		}
+		listing.Dirs = append(listing.Dirs, link)
+	}
+	sort.Slice(listing.Dirs, func(i, j int) bool {
+		return listing.Dirs[i].Name < listing.Dirs[j].Name
+	})
+	sort.Slice(listing.Documents, func(i, j int) bool {
+		return listing.Documents[i].path < listing.Documents[j].path
+	})
+	return listing, true
+}
+
+// listingDirs are the folders holding documents that have no landing
+// page, in order.
+func (r *repo) listingDirs() []string {
+	seen := make(map[string]bool)
+	for p := range r.documents {
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			seen[dir] = true
+		}
+	}
+
+	var dirs []string
+	for dir := range seen {
+		if d, ok := r.documents[dir]; !ok || !d.isLandingPage() {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// listingPage is the page a generated folder listing is rendered into.
+func (s *Site) listingPage(dir string) pageData {
+	data := s.newPage(nil)
+	data.PageTitle = path.Base(dir)
+
+	segments := strings.Split(dir, "/")
+	data.Breadcrumbs = []pageLink{{Name: s.siteTitle(), URL: s.basePath + "/"}}
+	for i, segment := range segments[:len(segments)-1] {
+		data.Breadcrumbs = append(data.Breadcrumbs, pageLink{Name: segment, URL: s.basePath + "/" + strings.Join(segments[:i+1], "/") + "/"})
+	}
+	data.Breadcrumbs = append(data.Breadcrumbs, pageLink{Name: segments[len(segments)-1]})
+	return data
+}
+
+// serveListing writes the generated listing of dir, if -autoindex is set
+// and dir holds documents, reporting whether it did.
+func (s *Site) serveListing(w http.ResponseWriter, r *http.Request, dir string) bool {
+	if !s.autoindex {
+		return false
+	}
+	listing, ok := s.activeRepo().Listing(dir)
+	if !ok {
+		return false
+	}
+
+	// Like landing pages, listings need the trailing slash so relative
+	// links resolve inside the folder.
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return true
+	}
+
+	s.servePage(w, r, s.listingPage(dir), "autoindex", listing)
+	return true
+}
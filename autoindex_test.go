@@ -0,0 +1,66 @@
+package thoughts
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSiteAutoindex(t *testing.T) {
+	files := map[string]string{
+		"README.md":             "# Index",
+		"notes/b.md":            "# Bee",
+		"notes/a.md":            "# Ay",
+		"notes/deep/c.md":       "# Sea",
+		"notes/guide/README.md": "# Guide",
+		"notes/guide/setup.md":  "# Setup",
+		"docs/README.md":        "# Real docs landing page",
+		"docs/more.md":          "# More",
+	}
+
+	s := newTestSite(t, files)
+	if rec := serve(s, "/notes/"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected folders to 404 without -autoindex, got %d", rec.Code)
+	}
+
+	s.autoindex = true
+	rec := serve(s, "/notes/")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected a listing, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`<a href="/notes/deep/">deep/</a>`,
+		`<a href="/notes/guide/">guide/</a>`,
+		`<a href="/notes/a">Ay</a>`,
+		`<a href="/notes/b">Bee</a>`,
+		"<title>test</title>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in %s", want, body)
+		}
+	}
+	if strings.Index(body, "Ay") > strings.Index(body, "Bee") {
+		t.Errorf("expected documents in path order, got %s", body)
+	}
+
+	if rec := serve(s, "/notes"); rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/notes/" {
+		t.Errorf("expected a redirect to /notes/, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if body := serve(s, "/docs/").Body.String(); !strings.Contains(body, "Real docs landing page") {
+		t.Errorf("expected the real README to win over a listing, got %s", body)
+	}
+	if body := serve(s, "/notes/deep/c").Body.String(); !strings.Contains(body, `<a href="/notes/">notes</a>`) {
+		t.Errorf("expected breadcrumbs to link to the listing, got %s", body)
+	}
+	if rec := serve(s, "/missing/"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected folders without documents to 404, got %d", rec.Code)
+	}
+}
+
+func serve(s *Site, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
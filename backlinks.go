@@ -0,0 +1,101 @@
+package thoughts
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// outgoingLinks collects the repo-relative lookup keys of the relative
+// links in doc, the document at docPath, and the targets of its wiki links.
+// Links have already been through rewriteLinks, so they lack extensions.
+func outgoingLinks(doc ast.Node, docPath string) (links, wiki []string) {
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+
+		if isTOCMarker(node) {
+			return ast.SkipChildren
+		}
+
+		switch n := node.(type) {
+		case *ast.Link:
+			if key, ok := linkKey(string(n.Destination), docPath); ok {
+				links = append(links, key)
+			}
+		case *ast.Text:
+			for _, m := range wikiLinkRE.FindAllSubmatch(n.Literal, -1) {
+				wiki = append(wiki, string(m[1]))
+			}
+		}
+		return ast.GoToNext
+	})
+	return links, wiki
+}
+
+// linkKey resolves a link destination found in the document at docPath to
+// the key the linked document is stored under.
+func linkKey(dest, docPath string) (string, bool) {
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return "", false
+	}
+
+	p := u.Path
+	if !strings.HasPrefix(p, "/") {
+		p = path.Join(path.Dir(docPath), p)
+	}
+	p = strings.Trim(path.Clean(p), "/")
+	if p == "." || p == "" {
+		return "README", true
+	}
+	return p, true
+}
+
+// buildBacklinks maps each document's served path to the other documents
+// that link to it, ordered by path.
+func (r *repo) buildBacklinks(docs []*document, wiki map[string]*document) map[string][]*document {
+	backlinks := make(map[string][]*document)
+
+	for _, d := range docs {
+		if d != r.index && r.documents[d.servedPath()] != d {
+			continue // shadowed, so nobody can read its links
+		}
+
+		seen := make(map[*document]bool)
+		add := func(target *document) {
+			if target == nil || target == d || seen[target] {
+				return
+			}
+			seen[target] = true
+			backlinks[target.servedPath()] = append(backlinks[target.servedPath()], d)
+		}
+
+		for _, key := range d.links {
+			if r.isIndexKey(key) {
+				add(r.index)
+				continue
+			}
+			add(r.documents[key])
+		}
+		for _, target := range d.wikiTargets {
+			add(wiki[wikiKey(target)])
+		}
+	}
+
+	for _, sources := range backlinks {
+		sort.Slice(sources, func(i, j int) bool {
+			return sources[i].path < sources[j].path
+		})
+	}
+	return backlinks
+}
+
+// Backlinks returns the documents linking to the one served at path.
+func (r *repo) Backlinks(path string) []*document {
+	return r.backlinks[path]
+}
@@ -0,0 +1,53 @@
+package thoughts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRepoBacklinks(t *testing.T) {
+	r := newTestRepo(t, map[string]string{
+		"README.md":       "# Home\n\nStart with [a](./notes/a.md).",
+		"notes/README.md": "# Notes",
+		"notes/a.md":      "# A\n\nSee [b](./b.md#part), [[A]] itself and [the folder](./README.md).",
+		"notes/b.md":      "# B\n\nBack to [[A]], twice [A](a.md), [home](../README.md) and [out](https://example.com/a).",
+		"notes/orphan.md": "# Orphan",
+	})
+
+	names := func(docs []*document) string {
+		var paths []string
+		for _, d := range docs {
+			paths = append(paths, d.path)
+		}
+		return strings.Join(paths, ",")
+	}
+
+	tests := map[string]string{
+		"notes/a":      "README.md,notes/b.md",
+		"notes/b":      "notes/a.md",
+		"notes":        "notes/a.md",
+		"README":       "notes/b.md",
+		"notes/orphan": "",
+	}
+	for p, want := range tests {
+		if got := names(r.Backlinks(p)); got != want {
+			t.Errorf("Backlinks(%q) = %q, expected %q", p, got, want)
+		}
+	}
+}
+
+func TestSiteRendersBacklinks(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md": "# Home",
+		"a.md":      "# A",
+		"b.md":      "# Bee\n\nSee [[A]].",
+	})
+
+	body := get(t, s, "/a")
+	if !strings.Contains(body, "Linked from:") || !strings.Contains(body, `<a href="/b">Bee</a>`) {
+		t.Errorf("expected a backlink from b, got %s", body)
+	}
+	if body := get(t, s, "/b"); strings.Contains(body, "Linked from:") {
+		t.Errorf("expected no backlinks on b, got %s", body)
+	}
+}
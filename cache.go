@@ -0,0 +1,89 @@
+package thoughts
+
+import (
+	"container/list"
+	"sync"
+)
+
+// renderCache is a least-recently-used cache of rendered documents, bounded
+// by the total size of the cached bytes. It is safe for concurrent use.
+type renderCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type renderCacheEntry struct {
+	key   string
+	value []byte
+}
+
+func newRenderCache(maxBytes int64) *renderCache {
+	return &renderCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// renderCacheKey identifies a document's rendering at a repo hash.
+func renderCacheKey(hash, path string) string {
+	return hash + ":" + path
+}
+
+func (c *renderCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*renderCacheEntry).value, true
+}
+
+// Add stores value under key, evicting the least recently used entries
+// until the cache is back under budget. Values larger than the whole budget
+// are not cached.
+func (c *renderCache) Add(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if int64(len(value)) > c.maxBytes {
+		return
+	}
+
+	if e, ok := c.items[key]; ok {
+		entry := e.Value.(*renderCacheEntry)
+		c.size += int64(len(value)) - int64(len(entry.value))
+		entry.value = value
+		c.ll.MoveToFront(e)
+	} else {
+		c.items[key] = c.ll.PushFront(&renderCacheEntry{key: key, value: value})
+		c.size += int64(len(value))
+	}
+
+	for c.size > c.maxBytes {
+		c.removeOldest()
+	}
+}
+
+func (c *renderCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *renderCache) removeOldest() {
+	e := c.ll.Back()
+	if e == nil {
+		return
+	}
+
+	entry := c.ll.Remove(e).(*renderCacheEntry)
+	delete(c.items, entry.key)
+	c.size -= int64(len(entry.value))
+}
@@ -0,0 +1,158 @@
+package thoughts
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestRenderCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newRenderCache(10)
+
+	c.Add("a", []byte("1234"))
+	c.Add("b", []byte("1234"))
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+
+	// a was used more recently, so b is evicted to make room for c.
+	c.Add("c", []byte("1234"))
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("expected %s to be cached", key)
+		}
+	}
+
+	// Replacing a value accounts for the change in size.
+	c.Add("a", []byte("1234567"))
+	if c.Len() != 1 {
+		t.Errorf("expected growing a to evict c, got %d entries", c.Len())
+	}
+	if b, _ := c.Get("a"); string(b) != "1234567" {
+		t.Errorf("expected the replaced value, got %q", b)
+	}
+
+	c.Add("huge", make([]byte, 11))
+	if _, ok := c.Get("huge"); ok {
+		t.Error("expected values over the budget not to be cached")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("expected an oversized value not to evict anything")
+	}
+}
+
+func TestDocumentRenderUsesCache(t *testing.T) {
+	cache := newRenderCache(1 << 20)
+	opts := RenderOptions{cache: cache}
+
+	d, err := newDocument("a.md", "hash-1", []byte("# A"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Render(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get(renderCacheKey("hash-1", "a.md")); !ok {
+		t.Fatal("expected render to populate the cache")
+	}
+
+	cache.Add(renderCacheKey("hash-1", "a.md"), []byte("cached"))
+	if out, _ := d.Render(nil); string(out) != "cached" {
+		t.Fatalf("expected render to be served from the cache, got %q", out)
+	}
+
+	// The same path at a new hash is a different entry.
+	d, _ = newDocument("a.md", "hash-2", []byte("# A"), opts)
+	if out, _ := d.Render(nil); string(out) == "cached" {
+		t.Fatal("expected a new hash to miss the cache")
+	}
+}
+
+func TestDiskRenderCache(t *testing.T) {
+	c, err := newDiskRenderCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := RenderOptions{diskCache: c}
+
+	d, err := newDocument("a.md", "abc123", []byte("# Original"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Render(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart parses the document again. Different contents at the same
+	// hash can only render as the original if it came from disk.
+	restarted, err := newDocument("a.md", "abc123", []byte("# Changed"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := restarted.Render(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "Original") {
+		t.Errorf("expected the rendering from disk, got %s", out)
+	}
+
+	withMath := opts
+	withMath.Math = true
+	other, _ := newDocument("a.md", "abc123", []byte("# Changed"), withMath)
+	if out, _ := other.Render(nil); !strings.Contains(string(out), "Changed") {
+		t.Errorf("expected different render settings to miss the cache, got %s", out)
+	}
+
+	// An upgrade may render differently with the same flags.
+	defer func(v string) { version = v }(version)
+	version = "v-upgraded"
+	upgraded, _ := newDocument("a.md", "abc123", []byte("# Changed"), opts)
+	if out, _ := upgraded.Render(nil); !strings.Contains(string(out), "Changed") {
+		t.Errorf("expected a different build to miss the cache, got %s", out)
+	}
+
+	if err := c.Prune(opts, "def456"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("abc123", "a.md", opts); ok {
+		t.Error("expected renderings at an old hash to be pruned")
+	}
+}
+
+func TestSitePrunesDiskRenderCacheAfterSwap(t *testing.T) {
+	fp := &fakeProvider{hash: "hash1", files: newTestFS(map[string]string{"README.md": "# One"})}
+	s, err := newSite(log.New(io.Discard, "", 0), Options{RepoURL: "notes", Provider: fp, RenderDiskCache: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	opts := s.versionA.opts
+	cached := func(hash string) bool {
+		_, ok := opts.diskCache.Get(hash, "README.md", opts)
+		return ok
+	}
+
+	fp.hash = "hash2"
+	if err := s.swapRepos(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !cached("hash1") || !cached("hash2") {
+		t.Error("expected both buffers' renderings to be kept")
+	}
+
+	fp.hash = "hash3"
+	if err := s.swapRepos(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if cached("hash1") || !cached("hash2") || !cached("hash3") {
+		t.Errorf("expected only hash1 to be pruned, have hash1 %t, hash2 %t, hash3 %t", cached("hash1"), cached("hash2"), cached("hash3"))
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfig sets flags in fs from the YAML file at path, whose keys are
+// flag names. Flags already set on the command line are left alone, and
+// lists set repeatable flags once per item.
+func loadConfig(fs *flag.FlagSet, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var values map[string]any
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		return fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for key, value := range values {
+		if key == "config" || fs.Lookup(key) == nil {
+			return fmt.Errorf("unknown key %q in config %s", key, path)
+		}
+		if set[key] {
+			continue
+		}
+
+		items, ok := value.([]any)
+		if !ok {
+			items = []any{value}
+		}
+		for _, item := range items {
+			if err := fs.Set(key, fmt.Sprint(item)); err != nil {
+				return fmt.Errorf("invalid value for %q in config %s: %w", key, path, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "thoughts.yaml")
+	config := "site-title: notes\nno-nav: true\nshutdown-timeout: 30s\nrender-cache-size: 1024\nrepo:\n  - /work=josebalius/work\n  - /personal=josebalius/notes\n"
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var r listFlags
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&r, "repo", "")
+	title := fs.String("site-title", "thoughts", "")
+	noNav := fs.Bool("no-nav", false, "")
+	timeout := fs.Duration("shutdown-timeout", 5*time.Second, "")
+	cacheSize := fs.Int64("render-cache-size", 0, "")
+	if err := fs.Parse([]string{"-site-title", "from flags"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loadConfig(fs, path); err != nil {
+		t.Fatal(err)
+	}
+
+	if *title != "from flags" {
+		t.Errorf("expected the command line to win, got title %q", *title)
+	}
+	if !*noNav || *timeout != 30*time.Second || *cacheSize != 1024 {
+		t.Errorf("expected config values, got no-nav=%v shutdown-timeout=%s render-cache-size=%d", *noNav, *timeout, *cacheSize)
+	}
+	if got := r.String(); got != "/work=josebalius/work,/personal=josebalius/notes" {
+		t.Errorf("expected both repos, got %s", got)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{"site-titel: notes\n", `unknown key "site-titel"`},
+		{"config: other.yaml\n", `unknown key "config"`},
+		{"no-nav: sometimes\n", `invalid value for "no-nav"`},
+		{"site-title: [\n", "failed to parse config"},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "thoughts.yaml")
+		if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.String("site-title", "thoughts", "")
+		fs.Bool("no-nav", false, "")
+
+		err := loadConfig(fs, path)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.config, tt.err, err)
+		}
+	}
+}
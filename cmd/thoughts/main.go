@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/josebalius/thoughts"
+)
+
+// listFlags collects a flag such as -repo that may be repeated or comma
+// separated.
+type listFlags []string
+
+func (r *listFlags) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *listFlags) Set(v string) error {
+	*r = append(*r, splitList(v)...)
+	return nil
+}
+
+var (
+	repos      listFlags
+	excludes   listFlags
+	includes   listFlags
+	exportDir  = flag.String("export", "", "write the site as static html into this directory and exit instead of serving it")
+	configPath = flag.String("config", "", "path to a yaml file of flag values, flags on the command line take precedence")
+
+	useCache  = flag.Bool("use-cache", false, "use the cache, if true, it creates the cache and uses it if it exists")
+	siteTitle = flag.String("site-title", "thoughts", "the title of the site, unless the repo sets one in _config.yml or the README frontmatter")
+	noNav     = flag.Bool("no-nav", false, "do not render the navigation sidebar")
+	tplPath   = flag.String("template", "", "path to an html template to use instead of the built-in one")
+	cssPath   = flag.String("css", "", "path to a stylesheet to use instead of the built-in styles")
+
+	contentWidth = flag.String("content-width", thoughts.DefaultContentWidth, "the css width of the content column, e.g. 800px, 60em or 90%")
+	themeToggle  = flag.Bool("theme-toggle", false, "add a light/dark theme toggle to every page")
+	baseURL      = flag.String("base-url", "", "the public url of the site, e.g. https://example.com, used for absolute links")
+	basePath     = flag.String("base-path", "", "the path prefix the site is served under, e.g. /docs")
+	tlsCert      = flag.String("tls-cert", "", "path to a PEM certificate, serves https when set with -tls-key")
+	tlsKey       = flag.String("tls-key", "", "path to the PEM private key for -tls-cert")
+
+	autocertDomain   = flag.String("autocert-domain", "", "comma separated domains to get Let's Encrypt certificates for, serves https on :443 and redirects :80")
+	autocertCacheDir = flag.String("autocert-cache", "autocert", "the directory certificates from -autocert-domain are cached in")
+
+	basicAuthUser    = flag.String("basic-auth-user", "", "require http basic auth with this user, set with -basic-auth-pass")
+	basicAuthPass    = flag.String("basic-auth-pass", "", "the password for -basic-auth-user")
+	adminToken       = flag.String("admin-token", "", "a bearer token for POST /admin/sync, which is only served with this or -basic-auth-user, defaults to $THOUGHTS_ADMIN_TOKEN")
+	corsOrigins      = flag.String("cors-origins", "", "comma separated origins allowed to call the json api from a browser, or * for any")
+	trustedProxies   = flag.String("trusted-proxies", "", "comma separated CIDRs of proxies whose X-Forwarded-For and X-Real-IP headers give the client address")
+	rateLimit        = flag.Float64("rate-limit", 0, "requests per second each client ip may make, 0 for no limit")
+	rateBurst        = flag.Int("rate-burst", 20, "how many requests a client may make at once before -rate-limit applies")
+	csp              = flag.String("csp", "", "the Content-Security-Policy to send instead of the built-in one, e.g. to allow analytics scripts, with 'nonce-{nonce}' to let the template's scripts run, off to send none")
+	analyticsSnippet = flag.String("analytics-snippet", "", "html, or a file of it, added before </body> on every page, e.g. an analytics script, which -csp must allow")
+	autoindex        = flag.Bool("autoindex", false, "list the notes and folders of folders without a README instead of 404ing")
+	accessLog        = flag.Bool("access-log", false, "log every request with its status, size and duration")
+
+	faviconPath       = flag.String("favicon", "", "path to an icon to serve at /favicon.ico instead of the built-in one")
+	startupRetries    = flag.Int("startup-retries", 5, "how many times to retry the first sync before giving up")
+	robots            = flag.String("robots", "allow", "the robots.txt to serve: allow lets crawlers index the site, disallow asks them not to")
+	minifyHTML        = flag.Bool("minify", false, "minify served html, keeping whitespace in code blocks")
+	failOnBrokenLinks = flag.Bool("fail-on-broken-links", false, "fail to sync when a note links to a note that does not exist")
+	strict            = flag.Bool("strict", false, "fail to sync when the repo has no notes, no index or broken links, instead of logging a warning")
+
+	providerName    = flag.String("provider", "github", "where the repo is hosted: github, gitlab, for gitlab.com or a self-hosted instance, or git to clone any git url")
+	gitlabToken     = flag.String("gitlab-token", "", "a GitLab access token for private repos, defaults to $GITLAB_TOKEN")
+	gitDir          = flag.String("git-dir", "", "with -provider git, the directory to clone repos to and keep them in across restarts, e.g. cache/git, empty for a temporary one")
+	gitDepth        = flag.Int("git-depth", 1, "with -provider git, how many commits to clone and fetch, 0 for the whole history")
+	gitToken        = flag.String("git-token", "", "with -provider git, an access token for private repos over https, defaults to $GIT_TOKEN")
+	gitSSHKey       = flag.String("git-ssh-key", "", "with -provider git, a private key file for ssh urls, the ssh agent is used if empty")
+	maxZipSize      = flag.Int64("max-zip-size", 100<<20, "the maximum size in bytes of the repo zipball and of any file in it, 0 for no limit")
+	downloadRetries = flag.Int("download-retries", 3, "how many times to retry a zipball download that fails on the network or with a server error")
+
+	renderDiskCache   = flag.String("render-disk-cache", "", "a directory to keep rendered documents in across restarts, e.g. cache/rendered, empty to disable")
+	renderCacheSize   = flag.Int64("render-cache-size", 64<<20, "the maximum size in bytes of rendered documents kept in memory, 0 to disable")
+	renderConcurrency = flag.Int("render-concurrency", 0, "how many documents to render at once after a sync, 0 for GOMAXPROCS")
+	shutdownTimeout   = flag.Duration("shutdown-timeout", 5*time.Second, "how long to let in-flight requests finish on shutdown, 0 to wait indefinitely")
+
+	allowRawHTML = flag.Bool("allow-raw-html", false, "skip sanitizing rendered html, only use with trusted single-author repos")
+	mermaid      = flag.Bool("mermaid", false, "render ```mermaid code blocks as diagrams, loading mermaid.js on pages that use them")
+	math         = flag.Bool("math", false, "typeset $inline$ and $$block$$ math with KaTeX, escape literal dollars as \\$")
+
+	noHeadingAnchors = flag.Bool("no-heading-anchors", false, "do not add a # link to each heading")
+	syntaxExtensions = flag.String("markdown-extensions", thoughts.DefaultExtensions, "comma separated markdown extensions to parse, prefix one with - to turn it off, e.g. common,-tables")
+	linksNewTab      = flag.Bool("links-new-tab", false, "open every link in a new tab, not just links to other sites")
+	smartTypography  = flag.Bool("smart-typography", true, "render straight quotes, -- and --- and ... as curly quotes, dashes and ellipses outside code")
+	emoji            = flag.Bool("emoji", false, "render :shortcode: emoji such as :tada: outside code as emoji characters")
+	copyCode         = flag.Bool("copy-code", false, "add a button to code blocks that copies the code")
+	codeLineNumbers  = flag.Bool("code-line-numbers", false, "number the lines of code blocks, without the numbers being copied")
+	inlineImages     = flag.Bool("inline-images", false, "with -export, embed the repo's images in pages as data URIs so they are self-contained")
+	stripComments    = flag.Bool("strip-comments", true, "remove <!-- html comments --> from notes, rendered or at /raw/, leaving those in code alone")
+	indexFile        = flag.String("index-file", "README.md", "the markdown file at the repo root to serve as the home page, e.g. index.md")
+	wordsPerMinute   = flag.Int("words-per-minute", thoughts.DefaultWordsPerMinute, "the reading speed reading time estimates are based on, 0 to hide them")
+)
+
+func main() {
+	flag.Var(&repos, "repo", "the repo to use, repeat or comma separate as /path=repo to serve several under their own paths")
+	flag.Var(&includes, "include", "a glob of repo paths to publish, e.g. notes/**, only matching notes are served when set, repeat or comma separate for more")
+	flag.Var(&excludes, "exclude", "a glob of repo paths to leave off the site, e.g. drafts/*, repeat or comma separate for more, added to .thoughtsignore")
+	flag.Parse()
+	if *configPath != "" {
+		if err := loadConfig(flag.CommandLine, *configPath); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	opts := thoughts.Options{
+		SiteTitle: *siteTitle,
+		UseCache:  *useCache,
+		NoNav:     *noNav,
+		Render: thoughts.RenderOptions{
+			AllowRawHTML: *allowRawHTML,
+			Mermaid:      *mermaid,
+			Math:         *math,
+
+			NoHeadingAnchors: *noHeadingAnchors,
+			WordsPerMinute:   *wordsPerMinute,
+			LinksNewTab:      *linksNewTab,
+
+			NoSmartTypography: !*smartTypography,
+			KeepComments:      !*stripComments,
+			Emoji:             *emoji,
+			CopyCode:          *copyCode,
+			CodeLineNumbers:   *codeLineNumbers,
+			InlineImages:      *inlineImages,
+			IndexFile:         *indexFile,
+		},
+		TemplatePath:    *tplPath,
+		CSSPath:         *cssPath,
+		ThemeToggle:     *themeToggle,
+		BaseURL:         *baseURL,
+		ProviderName:    *providerName,
+		GitLabToken:     *gitlabToken,
+		GitDir:          *gitDir,
+		GitDepth:        *gitDepth,
+		GitToken:        *gitToken,
+		GitSSHKey:       *gitSSHKey,
+		MaxZipSize:      *maxZipSize,
+		DownloadRetries: *downloadRetries,
+		RenderCacheSize: *renderCacheSize,
+		ShutdownTimeout: *shutdownTimeout,
+		BasePath:        *basePath,
+		TLSCert:         *tlsCert,
+		TLSKey:          *tlsKey,
+
+		AutocertDomains:  splitList(*autocertDomain),
+		AutocertCacheDir: *autocertCacheDir,
+		BasicAuthUser:    *basicAuthUser,
+		BasicAuthPass:    *basicAuthPass,
+		AdminToken:       *adminToken,
+		AccessLog:        *accessLog,
+		CORSOrigins:      splitList(*corsOrigins),
+		TrustedProxies:   splitList(*trustedProxies),
+		RateLimit:        *rateLimit,
+		RateBurst:        *rateBurst,
+		CSP:              *csp,
+		AnalyticsSnippet: *analyticsSnippet,
+		Autoindex:        *autoindex,
+		NoReadingTime:    *wordsPerMinute <= 0,
+
+		FailOnBrokenLinks: *failOnBrokenLinks,
+		Strict:            *strict,
+		RenderConcurrency: *renderConcurrency,
+		Minify:            *minifyHTML,
+		Robots:            *robots,
+		FaviconPath:       *faviconPath,
+		StartupRetries:    *startupRetries,
+		Exclude:           excludes,
+		Include:           includes,
+		ExportDir:         *exportDir,
+		RenderDiskCache:   *renderDiskCache,
+		ContentWidth:      *contentWidth,
+	}
+
+	if opts.GitLabToken == "" {
+		opts.GitLabToken = os.Getenv("GITLAB_TOKEN")
+	}
+	if opts.GitToken == "" {
+		opts.GitToken = os.Getenv("GIT_TOKEN")
+	}
+	if opts.AdminToken == "" {
+		opts.AdminToken = os.Getenv("THOUGHTS_ADMIN_TOKEN")
+	}
+
+	extensions, err := thoughts.ParseExtensions(*syntaxExtensions)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	opts.Render.Extensions = extensions
+
+	if m := parseMounts(repos); len(m) == 1 && m[0].Path == "" {
+		opts.RepoURL = m[0].RepoURL
+	} else {
+		opts.Mounts = m
+	}
+
+	if err := run(ctx, opts); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, opts thoughts.Options) error {
+	site, err := thoughts.NewSite(opts)
+	if err != nil {
+		return fmt.Errorf("failed to create site: %w", err)
+	}
+
+	if opts.ExportDir != "" {
+		return site.Export(ctx, opts.ExportDir)
+	}
+	return site.Serve(ctx)
+}
+
+func parseMounts(values []string) []thoughts.Mount {
+	mounts := make([]thoughts.Mount, 0, len(values))
+	for _, v := range values {
+		mounts = append(mounts, thoughts.ParseMount(v))
+	}
+	return mounts
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,98 @@
+package thoughts
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// sharedContents lets both repo buffers sync from one download: the
+// contents fetched for a hash are kept open and handed to every Contents
+// call until the provider reports a new hash. Each buffer rebuilds on a
+// different tick, so without this a swap cycle downloads every hash
+// twice.
+type sharedContents struct {
+	fp FileProvider
+
+	mu      sync.Mutex
+	hash    string // the last hash the provider reported
+	current *fetchedContents
+}
+
+// fetchedContents is one download. It holds a reference to itself while
+// it is current, so it is cleaned up once it is superseded and nobody is
+// reading it.
+type fetchedContents struct {
+	hash    string
+	fs      fs.FS
+	cleanup func()
+	refs    int
+}
+
+func newSharedContents(fp FileProvider) *sharedContents {
+	return &sharedContents{fp: fp}
+}
+
+func (s *sharedContents) LastHash(ctx context.Context) (string, error) {
+	hash, err := s.fp.LastHash(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	s.hash = hash
+	s.mu.Unlock()
+	return hash, nil
+}
+
+func (s *sharedContents) Contents(ctx context.Context) (fs.FS, func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c := s.current; c == nil || c.hash != s.hash {
+		contents, cleanup, err := s.fp.Contents(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c != nil {
+			c.release()
+		}
+		s.current = &fetchedContents{hash: s.hash, fs: contents, cleanup: cleanup, refs: 1}
+	}
+
+	c := s.current
+	c.refs++
+	var once sync.Once
+	return c.fs, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			c.release()
+		})
+	}, nil
+}
+
+// Close releases the contents kept for the current hash, and closes the
+// provider if it holds on to files of its own, like a temporary clone.
+func (s *sharedContents) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current != nil {
+		s.current.release()
+		s.current = nil
+	}
+	if c, ok := s.fp.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// release drops a reference, cleaning up after the last one. The caller
+// holds the sharedContents lock.
+func (c *fetchedContents) release() {
+	c.refs--
+	if c.refs == 0 {
+		c.cleanup()
+	}
+}
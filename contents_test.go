@@ -0,0 +1,62 @@
+package thoughts
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log"
+	"testing"
+	"time"
+)
+
+// countingProvider counts downloads and cleanups.
+type countingProvider struct {
+	fakeProvider
+	contents, cleanups int
+}
+
+func (c *countingProvider) Contents(ctx context.Context) (fs.FS, func(), error) {
+	c.contents++
+	return c.files, func() { c.cleanups++ }, nil
+}
+
+func TestSharedContentsAcrossSwap(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	fp := &countingProvider{fakeProvider: fakeProvider{hash: "abc123", files: newTestFS(map[string]string{"README.md": "# First"})}}
+	shared := newSharedContents(fp)
+	a, b := newRepo(logger, shared, RenderOptions{}), newRepo(logger, shared, RenderOptions{})
+	s := &Site{logger: logger, versionA: a, versionB: b, startupRetryDelay: time.Millisecond}
+	s.active.Store(a)
+
+	ctx := context.Background()
+	if err := s.initialSync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.warmStandby(ctx)
+	if fp.contents != 1 {
+		t.Fatalf("expected one download for both buffers at startup, got %d", fp.contents)
+	}
+
+	// A push: B rebuilds and takes over, then A catches up on the next tick.
+	fp.hash = "def456"
+	fp.files = newTestFS(map[string]string{"README.md": "# Second"})
+	for _, r := range []*repo{b, a} {
+		if err := r.Sync(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Index().Title(); got != "Second" {
+			t.Errorf("expected the new index, got %q", got)
+		}
+	}
+	if fp.contents != 2 {
+		t.Errorf("expected one download per hash, got %d", fp.contents)
+	}
+	if fp.cleanups != 1 {
+		t.Errorf("expected the first download to be cleaned up once superseded, got %d cleanups", fp.cleanups)
+	}
+
+	shared.Close()
+	if fp.cleanups != 2 {
+		t.Errorf("expected Close to clean up the current download, got %d cleanups", fp.cleanups)
+	}
+}
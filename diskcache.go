@@ -0,0 +1,97 @@
+package thoughts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// diskRenderCache keeps rendered documents on disk so a restart doesn't
+// render everything again. Entries live under a directory per repo hash and
+// render settings, and directories for anything else are pruned on sync.
+type diskRenderCache struct {
+	dir string
+}
+
+func newDiskRenderCache(dir string) (*diskRenderCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create render cache %s: %w", dir, err)
+	}
+	return &diskRenderCache{dir: dir}, nil
+}
+
+// version is the directory the renderings at hash with opts are kept in.
+func (c *diskRenderCache) version(hash string, opts RenderOptions) string {
+	return hash + "-" + opts.fingerprint()
+}
+
+func (c *diskRenderCache) file(hash, path string, opts RenderOptions) string {
+	return filepath.Join(c.dir, c.version(hash, opts), filepath.FromSlash(path)+".html")
+}
+
+func (c *diskRenderCache) Get(hash, path string, opts RenderOptions) ([]byte, bool) {
+	b, err := os.ReadFile(c.file(hash, path, opts))
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// Add stores a rendering. Failing to write only costs a render after the
+// next restart, so errors are dropped.
+func (c *diskRenderCache) Add(hash, path string, opts RenderOptions, b []byte) {
+	p := c.file(hash, path, opts)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return
+	}
+
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return
+	}
+	_ = os.Rename(tmp, p)
+}
+
+// Prune removes the renderings of every version but those at hashes.
+func (c *diskRenderCache) Prune(opts RenderOptions, hashes ...string) error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read render cache: %w", err)
+	}
+
+	keep := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		keep[c.version(hash, opts)] = true
+	}
+	for _, e := range entries {
+		if keep[e.Name()] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(c.dir, e.Name())); err != nil {
+			return fmt.Errorf("failed to prune render cache: %w", err)
+		}
+	}
+	return nil
+}
+
+// renderCacheSchema is bumped whenever rendering changes in a way the
+// build version can't be relied on to catch, such as in development builds
+// without one.
+const renderCacheSchema = 1
+
+// fingerprint identifies the settings and the build that change how
+// documents render, so restarting with different flags or after an
+// upgrade doesn't serve stale html.
+func (o RenderOptions) fingerprint() string {
+	build := buildVersion()
+	settings := fmt.Sprintf("%d %q %q %t %t %t %q %t %t %d %t %t %t %t %t %t",
+		renderCacheSchema, build.Version, build.Commit,
+		o.AllowRawHTML, o.Mermaid, o.Math, o.basePath, o.NoHeadingAnchors,
+		o.LinksNewTab, o.Extensions, o.NoSmartTypography, o.Emoji, o.CopyCode,
+		o.CodeLineNumbers, o.InlineImages, o.KeepComments)
+
+	sum := sha256.Sum256([]byte(settings))
+	return hex.EncodeToString(sum[:6])
+}
@@ -1,39 +1,661 @@
-package main
+package thoughts
 
 import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"path"
 	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
 	"github.com/gomarkdown/markdown/html"
 	"github.com/gomarkdown/markdown/parser"
+	"github.com/microcosm-cc/bluemonday"
+	"gopkg.in/yaml.v3"
 )
 
+// RenderOptions controls how markdown documents are turned into HTML.
+type RenderOptions struct {
+	// AllowRawHTML skips sanitization, so raw HTML in markdown (including
+	// scripts) is served as written. Only safe for trusted authors.
+	AllowRawHTML bool
+
+	// cache holds rendered documents across repo versions. Documents are
+	// rendered on every call when it is nil.
+	cache *renderCache
+
+	// diskCache, when set, keeps rendered documents on disk across
+	// restarts. It is checked after cache.
+	diskCache *diskRenderCache
+
+	// Mermaid renders ```mermaid code blocks as diagrams.
+	Mermaid bool
+
+	// Math parses $inline$ and $$block$$ LaTeX for KaTeX to typeset.
+	// Escaped dollars (\$) are left as text.
+	Math bool
+
+	// basePath is the path prefix the site is mounted under, such as
+	// "/docs". It is prepended to document URLs.
+	basePath string
+
+	// NoHeadingAnchors leaves out the "#" link after each heading.
+	NoHeadingAnchors bool
+
+	// WordsPerMinute is the reading speed reading times are estimated
+	// with. Zero uses DefaultWordsPerMinute.
+	WordsPerMinute int
+
+	// LinksNewTab opens every link in a new tab, not just external ones.
+	LinksNewTab bool
+
+	// Extensions are the markdown syntax extensions to parse. Zero uses
+	// DefaultExtensions.
+	Extensions parser.Extensions
+
+	// NoSmartTypography keeps straight quotes, -- and ... as written
+	// instead of rendering curly quotes, dashes and ellipses.
+	NoSmartTypography bool
+
+	// Emoji renders :shortcode: emoji outside code as their characters.
+	Emoji bool
+
+	// CopyCode adds a button to code blocks that copies their code.
+	CopyCode bool
+
+	// CodeLineNumbers numbers the lines of code blocks.
+	CodeLineNumbers bool
+
+	// InlineImages embeds the repo's images in documents as data URIs,
+	// so exported pages are self-contained.
+	InlineImages bool
+
+	// IndexFile is the root file served as the index, when it isn't
+	// README.md.
+	IndexFile string
+
+	// KeepComments leaves HTML comments in the rendered output and /raw/
+	// instead of removing them. Comments in code are always kept.
+	KeepComments bool
+}
+
+// indexKey is the lookup key other documents link to the index by, or ""
+// when that's README.
+func (o RenderOptions) indexKey() string {
+	if o.IndexFile == "" {
+		return ""
+	}
+	p, _ := trimMarkdownExt(o.IndexFile)
+	return p
+}
+
+// DefaultWordsPerMinute is a typical adult reading speed for prose.
+const DefaultWordsPerMinute = 200
+
+// frontmatter is the optional YAML block at the top of a document,
+// delimited by "---" lines.
+type frontmatter struct {
+	// Weight orders the document in navigation, lower first. Documents
+	// without a weight sort alphabetically after weighted ones.
+	Weight *int `yaml:"weight"`
+
+	// Date is used when the filename doesn't start with one.
+	Date string `yaml:"date"`
+
+	// Tags list the document on each tag's page. Both a YAML list and a
+	// comma separated string work.
+	Tags tagList `yaml:"tags"`
+
+	// Permalink serves the document at this path instead of the one its
+	// filename gives it. The index ignores it.
+	Permalink string `yaml:"permalink"`
+
+	// Title and Description, on the index only, name and describe the
+	// site. A _config.yml in the repo takes precedence.
+	Title       string `yaml:"title"`
+	Description string `yaml:"description"`
+}
+
 type document struct {
 	path     string
+	source   []byte
 	contents []byte
-	cache    []byte
+	meta     frontmatter
+	title    string
+	excerpt  string
+	date     time.Time
+	mermaid  bool
+	math     bool
+	copyCode bool
+	words    int
+	hash     string
+	opts     RenderOptions
+
+	// order is the document's position in the repo's order file, from
+	// one, or zero when it isn't listed.
+	order int
+
+	// permalink is the cleaned frontmatter permalink, if any.
+	permalink string
+
+	// problems are what was wrong with the file that the document was
+	// built around, such as frontmatter that didn't parse, for the sync
+	// to report.
+	problems []error
+
+	// links and wikiTargets are where the document links to, for
+	// backlinks.
+	links       []string
+	wikiTargets []string
+
+	// images maps image destinations to data URIs, with inlineImages.
+	images map[string]string
+}
+
+// maxExcerptLength bounds Excerpt, in runes, to roughly what link previews
+// and search results display.
+const maxExcerptLength = 160
+
+// linkRE matches the destination of an inline markdown link or image,
+// along with an optional quoted title.
+var linkRE = regexp.MustCompile(`(\]\()([^)\s]+)((?:\s+"[^"]*")?\))`)
+
+// sanitizer strips anything from rendered markdown that could run in a
+// visitor's browser. bluemonday policies are safe for concurrent use.
+var sanitizer = newSanitizer()
+
+// inlineImageSanitizer also keeps the data URIs of inlined images.
+var inlineImageSanitizer = func() *bluemonday.Policy {
+	p := newSanitizer()
+	p.AllowDataURIImages()
+	return p
+}()
+
+func newSanitizer() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("target").Matching(regexp.MustCompile(`^_blank$`)).OnElements("a")
+	p.AllowAttrs("rel").Matching(bluemonday.SpaceSeparatedTokens).OnElements("a")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^mermaid$`)).OnElements("div")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^anchor$`)).OnElements("a")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^wikilink-missing$`)).OnElements("span")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^callout callout-(note|tip|important|warning|caution)$`)).OnElements("div")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^callout-title$`)).OnElements("p")
+	p.AllowAttrs("aria-label").OnElements("a")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^math (inline|display)$`)).OnElements("span")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^footnote-ref$`)).OnElements("sup")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^footnote-return$`)).OnElements("a")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^footnotes$`)).OnElements("div")
+	p.AllowElements("button")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^code-block$`)).OnElements("div")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^copy-code$`)).OnElements("button")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^button$`)).OnElements("button")
+	p.AllowAttrs("aria-label").OnElements("button")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^line-numbers$`)).OnElements("pre")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^line$`)).OnElements("span")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^toc$`)).OnElements("div")
+	return p
+}
+
+// newDocument parses a markdown file found at path in the repo at hash.
+func newDocument(path, hash string, contents []byte, opts RenderOptions) (*document, error) {
+	source := contents
+
+	var meta frontmatter
+	var problems []error
+	fm, contents := splitFrontmatter(contents)
+	if fm != nil {
+		// A document whose frontmatter doesn't parse is still served,
+		// just without its metadata.
+		if err := yaml.Unmarshal(fm, &meta); err != nil {
+			meta = frontmatter{}
+			problems = append(problems, fmt.Errorf("failed to parse frontmatter: %w", err))
+		}
+	}
+
+	contents = rewriteLinks(contents)
+	d := &document{path: path, source: source, contents: contents, meta: meta, hash: hash, opts: opts, problems: problems}
+	if meta.Permalink != "" && !d.isIndex() {
+		// A permalink that can't be served falls back to the path from
+		// the filename.
+		p, ok := cleanPath("/" + strings.Trim(strings.TrimSpace(meta.Permalink), "/"))
+		if ok && p != "." {
+			d.permalink = p
+		} else {
+			d.problems = append(d.problems, fmt.Errorf("invalid permalink %q, serving at %s", meta.Permalink, d.derivedPath()))
+		}
+	}
+	root := d.parse()
+	d.title, d.excerpt = summarize(root)
+	d.words = countWords(root)
+	d.links, d.wikiTargets = outgoingLinks(root, path)
+	d.mermaid = opts.Mermaid && hasCodeBlock(root, "mermaid")
+	d.math = opts.Math && hasMath(root)
+	d.copyCode = opts.CopyCode && hasCodeBlock(root, "")
+
+	if t, ok := filenameDate(path); ok {
+		d.date = t
+	} else if meta.Date != "" {
+		// A date that doesn't parse leaves the document undated.
+		t, err := parseDate(meta.Date)
+		if err != nil {
+			d.problems = append(d.problems, fmt.Errorf("failed to parse frontmatter date: %w", err))
+		}
+		d.date = t
+	}
+
+	return d, nil
+}
+
+var datePrefixRE = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}`)
+
+// filenameDate parses a leading YYYY-MM-DD from the base of p.
+func filenameDate(p string) (time.Time, bool) {
+	m := datePrefixRE.FindString(path.Base(p))
+	if m == "" {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.DateOnly, m)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+var dateLayouts = []string{time.DateOnly, time.RFC3339, time.DateTime, "2006-01-02 15:04", "2006-01-02T15:04"}
+
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q, expected YYYY-MM-DD", s)
+}
+
+// summarize finds the text of the document's title heading and first
+// paragraph.
+func summarize(doc ast.Node) (title, excerpt string) {
+	var firstHeading string
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+
+		switch n := node.(type) {
+		case *ast.Heading:
+			if firstHeading == "" {
+				firstHeading = plainText(n)
+			}
+			if title == "" && n.Level == 1 {
+				title = plainText(n)
+			}
+			return ast.SkipChildren
+		case *ast.Paragraph:
+			if excerpt == "" {
+				excerpt = truncate(plainText(n), maxExcerptLength)
+			}
+			return ast.SkipChildren
+		}
+		return ast.GoToNext
+	})
+
+	if title == "" {
+		title = firstHeading
+	}
+	return title, excerpt
+}
+
+// assignHeadingIDs gives each heading without an explicit id the slug of
+// its text. Repeats of a slug get -2, -3 and so on in document order, so a
+// heading keeps its id when other headings change.
+func assignHeadingIDs(doc ast.Node) {
+	var headings []*ast.Heading
+	used := make(map[string]bool)
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if h, ok := node.(*ast.Heading); ok && entering && !h.IsTitleblock {
+			if h.HeadingID != "" {
+				used[h.HeadingID] = true
+			} else {
+				headings = append(headings, h)
+			}
+		}
+		return ast.GoToNext
+	})
+
+	for _, h := range headings {
+		base := slugify(plainText(h))
+		if base == "" {
+			base = "section"
+		}
+		id := base
+		for n := 2; used[id]; n++ {
+			id = fmt.Sprintf("%s-%d", base, n)
+		}
+		used[id] = true
+		h.HeadingID = id
+	}
+}
+
+// plainText concatenates the text beneath node, dropping markup and raw
+// HTML.
+func plainText(node ast.Node) string {
+	var b strings.Builder
+	ast.WalkFunc(node, func(n ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		switch n := n.(type) {
+		case *ast.Text:
+			b.Write(n.Literal)
+		case *ast.Code:
+			b.Write(n.Literal)
+		case *ast.Softbreak, *ast.Hardbreak:
+			b.WriteString(" ")
+		}
+		return ast.GoToNext
+	})
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// countWords counts the words of prose in node, leaving out code blocks.
+func countWords(node ast.Node) int {
+	words := 0
+	ast.WalkFunc(node, func(n ast.Node, entering bool) ast.WalkStatus {
+		switch n := n.(type) {
+		case *ast.Text:
+			words += len(strings.Fields(string(n.Literal)))
+		case *ast.Code:
+			words += len(strings.Fields(string(n.Literal)))
+		}
+		return ast.GoToNext
+	})
+	return words
+}
+
+// truncate shortens s to at most n runes, cutting at a word boundary and
+// marking the cut with an ellipsis.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	runes := []rune(s)[:n-1]
+	cut := string(runes)
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
+
+// splitFrontmatter separates a leading "---" delimited block from the rest
+// of the document. fm is nil when the document has no frontmatter.
+func splitFrontmatter(contents []byte) (fm, body []byte) {
+	line, rest, ok := bytes.Cut(contents, []byte("\n"))
+	if !ok || string(bytes.TrimSpace(line)) != "---" {
+		return nil, contents
+	}
+
+	fm = rest[:0:0]
+	for len(rest) > 0 {
+		line, rest, _ = bytes.Cut(rest, []byte("\n"))
+		if string(bytes.TrimSpace(line)) == "---" {
+			return fm, rest
+		}
+		fm = append(fm, line...)
+		fm = append(fm, '\n')
+	}
+
+	// No closing delimiter, so this was never frontmatter.
+	return nil, contents
+}
+
+// rewriteLinks points links at other markdown files to the URL they are
+// served at, which is the same path without the markdown extension.
+func rewriteLinks(contents []byte) []byte {
+	return linkRE.ReplaceAllFunc(contents, func(m []byte) []byte {
+		parts := linkRE.FindSubmatch(m)
+		target := rewriteLinkTarget(string(parts[2]))
+		return []byte(string(parts[1]) + target + string(parts[3]))
+	})
+}
+
+func rewriteLinkTarget(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return target
+	}
+
+	p, fragment, _ := strings.Cut(target, "#")
+	p, ok := trimMarkdownExt(p)
+	if !ok {
+		return target
+	}
+
+	// READMEs are served at their folder's URL.
+	if path.Base(p) == "README" {
+		p = strings.TrimSuffix(p, "README")
+		if p == "" {
+			p = "./"
+		}
+	}
+
+	if fragment != "" {
+		p += "#" + fragment
+	}
+	return p
+}
+
+// isLandingPage reports whether the document is a README nested in a
+// folder, and so is served as that folder's landing page. A permalink
+// takes a README out of its folder.
+func (d *document) isLandingPage() bool {
+	p, _ := trimMarkdownExt(d.path)
+	return path.Base(p) == "README" && path.Dir(p) != "." && d.permalink == ""
+}
+
+// servedPath is the key the document is looked up by, which is also its URL
+// without the leading slash: its permalink, or the path from its filename.
+func (d *document) servedPath() string {
+	if d.permalink != "" {
+		return d.permalink
+	}
+	return d.derivedPath()
+}
+
+// isIndex reports whether the document is the site's index: the
+// -index-file, or any README at the root when none is set.
+func (d *document) isIndex() bool {
+	if d.opts.IndexFile != "" {
+		return d.path == d.opts.IndexFile
+	}
+	p, _ := trimMarkdownExt(d.path)
+	return p == "README"
+}
+
+// derivedPath is where the document's filename puts it. The index is
+// always keyed README.
+func (d *document) derivedPath() string {
+	if d.isIndex() {
+		return "README"
+	}
+	p, _ := trimMarkdownExt(d.path)
+	if path.Base(p) == "README" && path.Dir(p) != "." {
+		return path.Dir(p)
+	}
+	return p
+}
+
+// Title is the text of the document's first top-level heading, falling
+// back to its first heading of any level and then to its filename.
+func (d *document) Title() string {
+	if d.title == "" {
+		return path.Base(d.servedPath())
+	}
+	return d.title
 }
 
-var linkRE = regexp.MustCompile(`(\[[^]]+\]\(\.\/[^)]+?)\.md(\))`)
+// Date is when the document was written, from a YYYY-MM-DD filename prefix
+// or the frontmatter date. It is the zero time for undated documents.
+func (d *document) Date() time.Time {
+	return d.date
+}
+
+// UsesMermaid reports whether the page needs the Mermaid script loaded.
+func (d *document) UsesMermaid() bool {
+	return d.mermaid
+}
+
+// UsesMath reports whether the page needs KaTeX loaded.
+func (d *document) UsesMath() bool {
+	return d.math
+}
+
+// UsesCopyCode reports whether the document has code blocks with copy
+// buttons, which need the script that makes them work.
+func (d *document) UsesCopyCode() bool {
+	return d.copyCode
+}
+
+// Source is the file as it is in the repo, with its frontmatter and
+// original links. contents holds the copy that gets rendered.
+func (d *document) Source() []byte {
+	return d.source
+}
+
+// ReadingTime estimates how long the document takes to read, rounded to
+// the nearest minute, e.g. "~5 min read".
+func (d *document) ReadingTime() string {
+	minutes := d.readingMinutes()
+	if minutes < 1 {
+		return "< 1 min read"
+	}
+	return fmt.Sprintf("~%d min read", minutes)
+}
+
+// readingMinutes is the reading time rounded to the nearest minute.
+func (d *document) readingMinutes() int {
+	wpm := d.opts.WordsPerMinute
+	if wpm <= 0 {
+		wpm = DefaultWordsPerMinute
+	}
+	return (d.words + wpm/2) / wpm
+}
+
+// Tags returns the document's tags as written in its frontmatter.
+func (d *document) Tags() []string {
+	return d.meta.Tags
+}
+
+// Excerpt is the plain text of the document's first paragraph, truncated.
+func (d *document) Excerpt() string {
+	return d.excerpt
+}
 
-func newDocument(path string, contents []byte) (*document, error) {
-	contents = []byte(linkRE.ReplaceAllString(string(contents), `$1$2`))
-	return &document{path: path, contents: contents}, nil
+// URL is the absolute path the document is served at, including the
+// site's base path.
+func (d *document) URL() string {
+	p := d.servedPath()
+	switch {
+	case p == "README":
+		return d.opts.basePath + "/"
+	case d.isLandingPage():
+		return d.opts.basePath + "/" + p + "/"
+	}
+	return d.opts.basePath + "/" + p
 }
 
-func (d *document) Render() ([]byte, error) {
-	if d.cache != nil {
-		return d.cache, nil
+func (d *document) parse() ast.Node {
+	extensions := d.opts.Extensions
+	if extensions == 0 {
+		extensions, _ = ParseExtensions(DefaultExtensions)
+	}
+	if d.opts.Math {
+		// Math is only parsed when it gets typeset, since it mangles prose
+		// with dollar amounts otherwise.
+		extensions |= parser.MathJax
 	}
+	// Heading ids are assigned here rather than by the parser, whose
+	// suffixes for repeated headings shift when headings are reordered.
+	autoIDs := extensions&parser.AutoHeadingIDs != 0
+	extensions &^= parser.AutoHeadingIDs
 
-	extensions := parser.CommonExtensions | parser.AutoHeadingIDs | parser.NoEmptyLineBeforeBlock
 	p := parser.NewWithExtensions(extensions)
 	doc := p.Parse(d.contents)
+	if autoIDs {
+		assignHeadingIDs(doc)
+	}
+	return doc
+}
+
+// Render renders the document to HTML, resolving wiki links against wiki,
+// which may be nil.
+func (d *document) Render(wiki map[string]*document) ([]byte, error) {
+	key := renderCacheKey(d.hash, d.path)
+	if d.opts.cache != nil {
+		if b, ok := d.opts.cache.Get(key); ok {
+			return b, nil
+		}
+	}
+	if d.opts.diskCache != nil {
+		if b, ok := d.opts.diskCache.Get(d.hash, d.path, d.opts); ok {
+			if d.opts.cache != nil {
+				d.opts.cache.Add(key, b)
+			}
+			return b, nil
+		}
+	}
+
+	doc := d.parse()
+	transformTaskLists(doc)
+	transformCallouts(doc)
+	transformTOC(doc)
+	if !d.opts.KeepComments {
+		transformComments(doc)
+	}
+	transformLinkTargets(doc, d.opts.LinksNewTab)
+	transformWikiLinks(doc, wiki)
+	transformImages(doc, d.images)
+	if d.opts.Emoji {
+		transformEmoji(doc)
+	}
 
-	htmlFlags := html.CommonFlags | html.HrefTargetBlank
-	opts := html.RendererOptions{Flags: htmlFlags}
+	htmlFlags := html.CommonFlags | html.FootnoteReturnLinks
+	if d.opts.NoSmartTypography {
+		htmlFlags &^= html.Smartypants | html.SmartypantsFractions | html.SmartypantsDashes | html.SmartypantsLatexDashes
+	}
+	opts := html.RendererOptions{
+		Flags:          htmlFlags,
+		RenderNodeHook: d.opts.renderHook(),
+
+		// Footnote ids carry the document's path so they stay unique when
+		// several documents end up on one page.
+		FootnoteAnchorPrefix:       slugify(d.servedPath()) + "-",
+		FootnoteReturnLinkContents: "&#8617;",
+	}
 	renderer := html.NewRenderer(opts)
 
-	d.cache = markdown.Render(doc, renderer)
-	return d.cache, nil
+	out := markdown.Render(doc, renderer)
+	switch {
+	case d.opts.AllowRawHTML:
+	case d.opts.InlineImages:
+		out = inlineImageSanitizer.SanitizeBytes(out)
+	default:
+		out = sanitizer.SanitizeBytes(out)
+	}
+
+	if d.opts.cache != nil {
+		d.opts.cache.Add(key, out)
+	}
+	if d.opts.diskCache != nil {
+		d.opts.diskCache.Add(d.hash, d.path, d.opts, out)
+	}
+	return out, nil
 }
@@ -0,0 +1,229 @@
+package thoughts
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func renderString(t *testing.T, markdown string, opts RenderOptions) string {
+	t.Helper()
+
+	d, err := newDocument("test.md", "hash", []byte(markdown), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := d.Render(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestDocumentRenderSanitizesHTML(t *testing.T) {
+	markdown := "# Title\n\n<script>alert('xss')</script>\n\n<a href=\"javascript:alert(1)\" onclick=\"alert(2)\">click</a>\n"
+
+	out := renderString(t, markdown, RenderOptions{})
+	for _, bad := range []string{"<script", "javascript:", "onclick"} {
+		if strings.Contains(out, bad) {
+			t.Errorf("expected %q to be stripped, got %s", bad, out)
+		}
+	}
+	if !strings.Contains(out, `<h1 id="title">Title`) {
+		t.Errorf("expected heading to survive sanitization, got %s", out)
+	}
+
+	out = renderString(t, markdown, RenderOptions{AllowRawHTML: true})
+	if !strings.Contains(out, "<script>") {
+		t.Errorf("expected raw html to be kept with allowRawHTML, got %s", out)
+	}
+}
+
+func TestRewriteLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{"sibling", "[a](./other.md)", "[a](./other)"},
+		{"bare", "[a](other.md)", "[a](other)"},
+		{"parent", "[a](../index.md)", "[a](../index)"},
+		{"nested", "[a](./notes/go/tips.md)", "[a](./notes/go/tips)"},
+		{"parent nested", "[a](../projects/go/notes.md)", "[a](../projects/go/notes)"},
+		{"fragment", "[a](./foo.md#section)", "[a](./foo#section)"},
+		{"markdown extension", "[a](./foo.markdown)", "[a](./foo)"},
+		{"title", `[a](./foo.md "Foo")`, `[a](./foo "Foo")`},
+		{"external", "[a](https://example.com/foo.md)", "[a](https://example.com/foo.md)"},
+		{"not markdown", "[a](./image.png)", "[a](./image.png)"},
+		{"fragment only", "[a](#section)", "[a](#section)"},
+		{"readme", "[a](./README.md)", "[a](./)"},
+		{"parent readme", "[a](../README.md)", "[a](../)"},
+		{"nested readme", "[a](./sub/README.md#x)", "[a](./sub/#x)"},
+		{"multiple", "[a](./a.md) and [b](../b.md#x)", "[a](./a) and [b](../b#x)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(rewriteLinks([]byte(tt.markdown)))
+			if got != tt.want {
+				t.Errorf("rewriteLinks(%q) = %q, want %q", tt.markdown, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocumentFrontmatter(t *testing.T) {
+	d, err := newDocument("a.md", "hash", []byte("---\nweight: 3\n---\n# A\n"), RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.meta.Weight == nil || *d.meta.Weight != 3 {
+		t.Fatalf("expected weight 3, got %v", d.meta.Weight)
+	}
+	if string(d.contents) != "# A\n" {
+		t.Fatalf("expected frontmatter to be stripped, got %q", d.contents)
+	}
+
+	d, err = newDocument("b.md", "hash", []byte("---\nnot closed\n"), RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.meta.Weight != nil || string(d.contents) != "---\nnot closed\n" {
+		t.Fatalf("expected unterminated frontmatter to be left alone, got %q", d.contents)
+	}
+
+	for _, source := range []string{"---\nweight: [\n---\n# C\n", "---\ntags:\n  a: b\n---\n# C\n"} {
+		d, err = newDocument("c.md", "hash", []byte(source), RenderOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(d.problems) != 1 || !strings.Contains(d.problems[0].Error(), "failed to parse frontmatter") {
+			t.Errorf("expected %q to report its frontmatter, got %v", source, d.problems)
+		}
+		if d.meta.Weight != nil || d.meta.Tags != nil || string(d.contents) != "# C\n" {
+			t.Errorf("expected %q to be served without metadata, got %q", source, d.contents)
+		}
+	}
+}
+
+func TestDocumentSummary(t *testing.T) {
+	long := strings.Repeat("word ", 100)
+	d, err := newDocument("a.md", "hash", []byte("## Intro\n\n# The *Title*\n\nFirst <b>para</b> with `code` and [a link](./b.md).\n\nSecond para.\n"), RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Title() != "The Title" {
+		t.Errorf("expected title from the h1, got %q", d.Title())
+	}
+	if d.Excerpt() != "First para with code and a link." {
+		t.Errorf("expected plain-text excerpt, got %q", d.Excerpt())
+	}
+
+	d, err = newDocument("b.md", "hash", []byte("## Only h2\n\n"+long), RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Title() != "Only h2" {
+		t.Errorf("expected title to fall back to the first heading, got %q", d.Title())
+	}
+	if n := len([]rune(d.Excerpt())); n > maxExcerptLength {
+		t.Errorf("expected excerpt of at most %d runes, got %d", maxExcerptLength, n)
+	}
+	if !strings.HasSuffix(d.Excerpt(), "word…") {
+		t.Errorf("expected excerpt to be cut at a word with an ellipsis, got %q", d.Excerpt())
+	}
+}
+
+func TestDocumentDate(t *testing.T) {
+	tests := []struct {
+		path, markdown string
+		want           string
+	}{
+		{"thoughts/2024-03-15.md", "# A", "2024-03-15"},
+		{"thoughts/2024-03-15-with-slug.md", "# A", "2024-03-15"},
+		{"thoughts/note.md", "---\ndate: 2023-07-01\n---\n# A", "2023-07-01"},
+		{"thoughts/note.md", "---\ndate: 2023-07-01T10:30:00Z\n---\n# A", "2023-07-01"},
+		{"thoughts/2024-03-15.md", "---\ndate: 2023-07-01\n---\n# A", "2024-03-15"},
+		{"thoughts/note.md", "# Undated", ""},
+		{"thoughts/2024-13-45.md", "# Bad date", ""},
+	}
+
+	for _, tt := range tests {
+		d, err := newDocument(tt.path, "hash", []byte(tt.markdown), RenderOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+
+		got := ""
+		if !d.Date().IsZero() {
+			got = d.Date().Format(time.DateOnly)
+		}
+		if got != tt.want {
+			t.Errorf("%s with %q: expected date %q, got %q", tt.path, tt.markdown, tt.want, got)
+		}
+	}
+
+	d, err := newDocument("a.md", "hash", []byte("---\ndate: soon\n---\n"), RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Date().IsZero() {
+		t.Errorf("expected an unparseable frontmatter date to leave the date zero, got %v", d.Date())
+	}
+	if len(d.problems) != 1 || !strings.Contains(d.problems[0].Error(), "failed to parse frontmatter date") {
+		t.Errorf("expected the date to be reported, got %v", d.problems)
+	}
+}
+
+func TestDocumentReadingTime(t *testing.T) {
+	words := func(n int) string {
+		return strings.TrimSpace(strings.Repeat("word ", n))
+	}
+
+	tests := []struct {
+		markdown string
+		want     string
+	}{
+		{"", "< 1 min read"},
+		{words(99), "< 1 min read"},
+		{words(100), "~1 min read"},
+		{words(299), "~1 min read"},
+		{words(300), "~2 min read"},
+		{"# Title\n\n" + words(999) + "\n\n```\n" + words(1000) + "\n```\n", "~5 min read"},
+		{"---\ndate: 2024-01-02\n---\n" + words(99), "< 1 min read"},
+	}
+
+	for _, tt := range tests {
+		d, err := newDocument("a.md", "hash", []byte(tt.markdown), RenderOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.ReadingTime(); got != tt.want {
+			t.Errorf("%d words: expected %q, got %q", d.words, tt.want, got)
+		}
+	}
+
+	d, err := newDocument("a.md", "hash", []byte(words(100)), RenderOptions{WordsPerMinute: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.ReadingTime(); got != "~2 min read" {
+		t.Errorf("expected the configured speed to be used, got %q", got)
+	}
+}
+
+func TestDocumentSource(t *testing.T) {
+	source := "---\nweight: 1\n---\n# A\n\nSee [b](./b.md#part) and [c](c/README.md).\n"
+	d, err := newDocument("a.md", "hash", []byte(source), RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(d.Source()); got != source {
+		t.Errorf("expected the source to be untouched, got %q", got)
+	}
+	if got := string(d.contents); strings.Contains(got, ".md") || strings.Contains(got, "weight") {
+		t.Errorf("expected contents to be rewritten for rendering, got %q", got)
+	}
+}
@@ -0,0 +1,70 @@
+package thoughts_test
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/josebalius/thoughts"
+)
+
+// memProvider serves files from memory, wrapped in a top-level directory
+// the way GitHub zipballs are.
+type memProvider struct {
+	hash  string
+	files map[string]string
+}
+
+func (p *memProvider) LastHash(ctx context.Context) (string, error) {
+	return p.hash, nil
+}
+
+func (p *memProvider) Contents(ctx context.Context) (fs.FS, func(), error) {
+	m := fstest.MapFS{}
+	for name, contents := range p.files {
+		m["notes-"+p.hash+"/"+name] = &fstest.MapFile{Data: []byte(contents)}
+	}
+	return m, func() {}, nil
+}
+
+func TestNewSite(t *testing.T) {
+	site, err := thoughts.NewSite(thoughts.Options{
+		RepoURL:   "josebalius/notes",
+		SiteTitle: "Notes",
+		Logger:    log.New(io.Discard, "", 0),
+		Provider: &memProvider{hash: "abc123", files: map[string]string{
+			"README.md": "# Hello\n\nSee [about](about.md).",
+			"about.md":  "# About\n\nWritten elsewhere.",
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := site.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var h http.Handler = site
+	for path, want := range map[string]string{
+		"/":      "<title>Notes</title>",
+		"/about": "Written elsewhere.",
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("GET %s: expected 200 with %q, got %d: %s", path, want, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestNewSiteRequiresRepo(t *testing.T) {
+	if _, err := thoughts.NewSite(thoughts.Options{}); err == nil || !strings.Contains(err.Error(), "repo url is required") {
+		t.Errorf("expected a missing repo to fail, got %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package thoughts
+
+import (
+	"regexp"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// emojiShortcodeRE matches GitHub-style :shortcode: emoji.
+var emojiShortcodeRE = regexp.MustCompile(`:[a-z0-9_+\-]+:`)
+
+// transformEmoji replaces known :shortcode: emoji in text with their
+// characters. Code spans and blocks aren't text nodes, so they keep the
+// shortcodes as written, and so do unknown shortcodes.
+func transformEmoji(doc ast.Node) {
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		text, ok := node.(*ast.Text)
+		if !entering || !ok {
+			return ast.GoToNext
+		}
+
+		text.Literal = replaceEmoji(text.Literal)
+		return ast.GoToNext
+	})
+}
+
+// replaceEmoji replaces the known shortcodes in text. An unknown match gives
+// back its closing colon, so it can open the next shortcode, as in
+// "10:30:tada:".
+func replaceEmoji(text []byte) []byte {
+	var out []byte
+	start := 0
+	for i := 0; i < len(text); {
+		loc := emojiShortcodeRE.FindIndex(text[i:])
+		if loc == nil {
+			break
+		}
+		from, to := i+loc[0], i+loc[1]
+		e, ok := emojiShortcodes[string(text[from+1:to-1])]
+		if !ok {
+			i = to - 1
+			continue
+		}
+		out = append(out, text[start:from]...)
+		out = append(out, e...)
+		start, i = to, to
+	}
+	if out == nil {
+		return text
+	}
+	return append(out, text[start:]...)
+}
+
+// emojiShortcodes maps the shortcodes GitHub supports for the most used emoji to
+// their characters.
+var emojiShortcodes = map[string]string{
+	"+1":                       "👍",
+	"-1":                       "👎",
+	"100":                      "💯",
+	"alarm_clock":              "⏰",
+	"angry":                    "😠",
+	"apple":                    "🍎",
+	"arrow_down":               "⬇️",
+	"arrow_left":               "⬅️",
+	"arrow_right":              "➡️",
+	"arrow_up":                 "⬆️",
+	"art":                      "🎨",
+	"baby":                     "👶",
+	"balloon":                  "🎈",
+	"bangbang":                 "‼️",
+	"beer":                     "🍺",
+	"bell":                     "🔔",
+	"bike":                     "🚲",
+	"bird":                     "🐦",
+	"blush":                    "😊",
+	"bomb":                     "💣",
+	"book":                     "📖",
+	"books":                    "📚",
+	"boom":                     "💥",
+	"bouquet":                  "💐",
+	"brain":                    "🧠",
+	"broken_heart":             "💔",
+	"bug":                      "🐛",
+	"bulb":                     "💡",
+	"cake":                     "🍰",
+	"calendar":                 "📆",
+	"camera":                   "📷",
+	"car":                      "🚗",
+	"cat":                      "🐱",
+	"chart_with_upwards_trend": "📈",
+	"checkered_flag":           "🏁",
+	"clap":                     "👏",
+	"clipboard":                "📋",
+	"clock":                    "🕐",
+	"cloud":                    "☁️",
+	"coffee":                   "☕",
+	"computer":                 "💻",
+	"confused":                 "😕",
+	"construction":             "🚧",
+	"cool":                     "🆒",
+	"cry":                      "😢",
+	"crystal_ball":             "🔮",
+	"dart":                     "🎯",
+	"dog":                      "🐶",
+	"earth_americas":           "🌎",
+	"email":                    "📧",
+	"exclamation":              "❗",
+	"eyes":                     "👀",
+	"fire":                     "🔥",
+	"flashlight":               "🔦",
+	"flower":                   "🌸",
+	"gem":                      "💎",
+	"ghost":                    "👻",
+	"gift":                     "🎁",
+	"globe_with_meridians":     "🌐",
+	"grimacing":                "😬",
+	"grin":                     "😁",
+	"grinning":                 "😀",
+	"hammer":                   "🔨",
+	"hand":                     "✋",
+	"heart":                    "❤️",
+	"heart_eyes":               "😍",
+	"heavy_check_mark":         "✔️",
+	"heavy_multiplication_x":   "✖️",
+	"hourglass":                "⌛",
+	"house":                    "🏠",
+	"hugs":                     "🤗",
+	"hushed":                   "😯",
+	"information_source":       "ℹ️",
+	"innocent":                 "😇",
+	"joy":                      "😂",
+	"key":                      "🔑",
+	"laughing":                 "😆",
+	"leaves":                   "🍃",
+	"link":                     "🔗",
+	"lock":                     "🔒",
+	"mag":                      "🔍",
+	"memo":                     "📝",
+	"moon":                     "🌔",
+	"muscle":                   "💪",
+	"musical_note":             "🎵",
+	"neutral_face":             "😐",
+	"no_entry":                 "⛔",
+	"ok_hand":                  "👌",
+	"open_mouth":               "😮",
+	"package":                  "📦",
+	"paperclip":                "📎",
+	"partying_face":            "🥳",
+	"pencil":                   "📝",
+	"pencil2":                  "✏️",
+	"pensive":                  "😔",
+	"point_down":               "👇",
+	"point_left":               "👈",
+	"point_right":              "👉",
+	"point_up":                 "☝️",
+	"pray":                     "🙏",
+	"pushpin":                  "📌",
+	"question":                 "❓",
+	"rage":                     "😡",
+	"rainbow":                  "🌈",
+	"raised_hands":             "🙌",
+	"recycle":                  "♻️",
+	"relaxed":                  "☺️",
+	"relieved":                 "😌",
+	"rocket":                   "🚀",
+	"rofl":                     "🤣",
+	"rose":                     "🌹",
+	"scream":                   "😱",
+	"see_no_evil":              "🙈",
+	"seedling":                 "🌱",
+	"shrug":                    "🤷",
+	"skull":                    "💀",
+	"sleeping":                 "😴",
+	"slightly_smiling_face":    "🙂",
+	"smile":                    "😄",
+	"smiley":                   "😃",
+	"smirk":                    "😏",
+	"snowflake":                "❄️",
+	"sob":                      "😭",
+	"sparkles":                 "✨",
+	"speech_balloon":           "💬",
+	"star":                     "⭐",
+	"star2":                    "🌟",
+	"stuck_out_tongue":         "😛",
+	"sun_with_face":            "🌞",
+	"sunglasses":               "😎",
+	"sunny":                    "☀️",
+	"sweat_smile":              "😅",
+	"tada":                     "🎉",
+	"thinking":                 "🤔",
+	"thought_balloon":          "💭",
+	"thumbsdown":               "👎",
+	"thumbsup":                 "👍",
+	"trophy":                   "🏆",
+	"tulip":                    "🌷",
+	"unamused":                 "😒",
+	"umbrella":                 "☔",
+	"v":                        "✌️",
+	"warning":                  "⚠️",
+	"wave":                     "👋",
+	"white_check_mark":         "✅",
+	"wink":                     "😉",
+	"wrench":                   "🔧",
+	"x":                        "❌",
+	"yum":                      "😋",
+	"zap":                      "⚡",
+	"zzz":                      "💤",
+}
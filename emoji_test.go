@@ -0,0 +1,39 @@
+package thoughts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderEmoji(t *testing.T) {
+	markdown := "Shipped :rocket: :tada: and :not_an_emoji: at 10:30:45\n\n`:rocket:`\n\n```\n:tada:\n```"
+
+	out := renderString(t, markdown, RenderOptions{Emoji: true})
+	for _, want := range []string{
+		"Shipped 🚀 🎉 and :not_an_emoji: at 10:30:45",
+		"<code>:rocket:</code>",
+		"<pre><code>:tada:",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %s in %s", want, out)
+		}
+	}
+
+	out = renderString(t, markdown, RenderOptions{})
+	if !strings.Contains(out, "Shipped :rocket: :tada:") {
+		t.Errorf("expected shortcodes left alone without -emoji, got %s", out)
+	}
+}
+
+func TestReplaceEmoji(t *testing.T) {
+	for text, want := range map[string]string{
+		"at 10:30:tada:":     "at 10:30🎉",
+		":nope:rocket: :x::": ":nope🚀 ❌:",
+		"no shortcodes here": "no shortcodes here",
+		"::tada::":           ":🎉:",
+	} {
+		if got := string(replaceEmoji([]byte(text))); got != want {
+			t.Errorf("replaceEmoji(%q): expected %q, got %q", text, want, got)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package thoughts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Export syncs the site once and writes every page as static html into dir,
+// mirroring the urls it is served at: documents as path.html, the index and
+// folder landing pages as index.html. Links stay extensionless, which
+// static hosts resolve to the .html file.
+func (s *Site) Export(ctx context.Context, dir string) error {
+	if len(s.mounts) > 0 {
+		index, err := s.renderNamedPage(s.newPage(nil), "mounts", s.mountLinks())
+		if err != nil {
+			return fmt.Errorf("failed to render mounts: %w", err)
+		}
+		if err := writeExportFile(dir, "index.html", index); err != nil {
+			return err
+		}
+		if err := s.exportStatic(dir); err != nil {
+			return err
+		}
+
+		for _, m := range s.mounts {
+			if err := m.Export(ctx, filepath.Join(dir, filepath.FromSlash(s.mountPath(m)))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if s.contents != nil {
+		defer s.contents.Close()
+	}
+
+	s.logger.Printf("syncing active repo for %s/\n", s.basePath)
+	if err := s.initialSync(ctx); err != nil {
+		return err
+	}
+
+	index, err := s.renderDocument(s.activeRepo().Index(), "")
+	if err != nil {
+		return fmt.Errorf("failed to render index: %w", err)
+	}
+	if err := writeExportFile(dir, "index.html", index); err != nil {
+		return err
+	}
+
+	for p, doc := range s.activeRepo().Documents() {
+		b, err := s.renderDocument(doc, "")
+		if err != nil {
+			return fmt.Errorf("failed to render %s: %w", p, err)
+		}
+
+		name := p + ".html"
+		if doc.isLandingPage() {
+			name = p + "/index.html"
+		}
+		if err := writeExportFile(dir, name, b); err != nil {
+			return err
+		}
+	}
+
+	if s.autoindex {
+		for _, folder := range s.activeRepo().listingDirs() {
+			listing, _ := s.activeRepo().Listing(folder)
+			b, err := s.renderNamedPage(s.listingPage(folder), "autoindex", listing)
+			if err != nil {
+				return fmt.Errorf("failed to render listing of %s: %w", folder, err)
+			}
+			if err := writeExportFile(dir, folder+"/index.html", b); err != nil {
+				return err
+			}
+		}
+	}
+
+	archive, err := s.renderNamedPage(s.archivePage(), "archive", s.activeRepo().Archive())
+	if err != nil {
+		return fmt.Errorf("failed to render archive: %w", err)
+	}
+	if err := writeExportFile(dir, "archive.html", archive); err != nil {
+		return err
+	}
+
+	tags, err := s.renderNamedPage(s.tagsPage(), "tags", s.activeRepo().Tags())
+	if err != nil {
+		return fmt.Errorf("failed to render tags: %w", err)
+	}
+	if err := writeExportFile(dir, "tags.html", tags); err != nil {
+		return err
+	}
+	for _, t := range s.activeRepo().Tags() {
+		b, err := s.renderNamedPage(s.tagPage(t), "tag", t)
+		if err != nil {
+			return fmt.Errorf("failed to render tag %s: %w", t.Name, err)
+		}
+		if err := writeExportFile(dir, "tags/"+t.Slug+".html", b); err != nil {
+			return err
+		}
+	}
+
+	feed, err := s.atomFeed(s.feedOrigin(nil))
+	if err != nil {
+		return fmt.Errorf("failed to build atom feed: %w", err)
+	}
+	if err := writeExportFile(dir, "feed.atom", feed); err != nil {
+		return err
+	}
+	if err := writeExportFile(dir, "llms.txt", []byte(s.llmsTxt(s.feedOrigin(nil)))); err != nil {
+		return err
+	}
+
+	if err := s.exportStatic(dir); err != nil {
+		return err
+	}
+
+	s.logger.Printf("exported %d documents to %s\n", len(s.activeRepo().Documents())+1, dir)
+	return nil
+}
+
+// exportStatic writes the files the site serves besides its pages.
+func (s *Site) exportStatic(dir string) error {
+	if err := writeExportFile(dir, "favicon.ico", s.icon().data); err != nil {
+		return err
+	}
+	return writeExportFile(dir, "robots.txt", []byte(s.robotsTxt()))
+}
+
+func writeExportFile(dir, name string, b []byte) error {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(p), err)
+	}
+	if err := os.WriteFile(p, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", p, err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package thoughts
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSiteExport(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	files := map[string]string{
+		"README.md":          "# Home\n\nWelcome.",
+		"about.md":           "# About\n\nAbout me.",
+		"thoughts/README.md": "# Thoughts\n\nAll of them.",
+		"thoughts/first.md":  "# First\n\nA first thought.",
+	}
+	r := newRepo(logger, &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{})
+	s := &Site{
+		title:    "test",
+		logger:   logger,
+		versionA: r,
+		versionB: r,
+		tpl:      mustParseWrapper(t),
+	}
+	s.active.Store(r)
+
+	dir := t.TempDir()
+	if err := s.Export(context.Background(), dir); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"index.html", "Welcome."},
+		{"about.html", "About me."},
+		{"thoughts/index.html", "All of them."},
+		{"thoughts/first.html", "A first thought."},
+		{"archive.html", "<h1>Archive</h1>"},
+		{"robots.txt", "User-agent: *"},
+	}
+	for _, tt := range tests {
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(tt.name)))
+		if err != nil {
+			t.Errorf("expected %s to be exported: %v", tt.name, err)
+			continue
+		}
+		if !strings.Contains(string(b), tt.want) {
+			t.Errorf("expected %s in %s, got %s", tt.want, tt.name, b)
+		}
+		if strings.HasSuffix(tt.name, ".html") && !strings.Contains(string(b), "<title>test</title>") {
+			t.Errorf("expected %s to be rendered through the template, got %s", tt.name, b)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "favicon.ico")); err != nil {
+		t.Errorf("expected the favicon to be exported: %v", err)
+	}
+}
+
+func TestSiteExportInlineImages(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	png := "\x89PNG\r\n\x1a\nfake"
+	files := map[string]string{
+		"README.md":         "# Home",
+		"notes/pic.md":      "# Pic\n\n![local](img/dot.png) ![root](/logo.png) ![remote](https://example.com/a.png) ![missing](gone.png)",
+		"notes/img/dot.png": png,
+		"logo.png":          png,
+	}
+	r := newRepo(logger, &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{InlineImages: true})
+	s := &Site{title: "test", logger: logger, versionA: r, versionB: r, tpl: mustParseWrapper(t)}
+	s.active.Store(r)
+
+	dir := t.TempDir()
+	if err := s.Export(context.Background(), dir); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "notes", "pic.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte(png))
+	if n := strings.Count(string(b), `src="`+uri+`"`); n != 2 {
+		t.Errorf("expected both local images inlined, got %d in %s", n, b)
+	}
+	for _, want := range []string{`src="https://example.com/a.png"`, `src="gone.png"`} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("expected %s to be left alone in %s", want, b)
+		}
+	}
+}
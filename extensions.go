@@ -0,0 +1,71 @@
+package thoughts
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gomarkdown/markdown/parser"
+)
+
+// DefaultExtensions is the -markdown-extensions value documents are parsed
+// with unless told otherwise.
+const DefaultExtensions = "common,auto-heading-ids,no-empty-line-before-block,footnotes"
+
+// markdownExtensionNames maps -markdown-extensions names onto parser flags.
+// Math is left to -math, and file includes are not offered since notes are
+// untrusted input.
+var markdownExtensionNames = map[string]parser.Extensions{
+	"common":                     parser.CommonExtensions &^ parser.MathJax,
+	"no-intra-emphasis":          parser.NoIntraEmphasis,
+	"tables":                     parser.Tables,
+	"fenced-code":                parser.FencedCode,
+	"autolink":                   parser.Autolink,
+	"strikethrough":              parser.Strikethrough,
+	"lax-html-blocks":            parser.LaxHTMLBlocks,
+	"space-headings":             parser.SpaceHeadings,
+	"hard-line-break":            parser.HardLineBreak,
+	"non-blocking-space":         parser.NonBlockingSpace,
+	"tab-size-eight":             parser.TabSizeEight,
+	"footnotes":                  parser.Footnotes,
+	"no-empty-line-before-block": parser.NoEmptyLineBeforeBlock,
+	"heading-ids":                parser.HeadingIDs,
+	"titleblock":                 parser.Titleblock,
+	"auto-heading-ids":           parser.AutoHeadingIDs,
+	"backslash-line-break":       parser.BackslashLineBreak,
+	"definition-lists":           parser.DefinitionLists,
+	"ordered-list-start":         parser.OrderedListStart,
+	"attributes":                 parser.Attributes,
+	"super-subscript":            parser.SuperSubscript,
+	"empty-lines-break-list":     parser.EmptyLinesBreakList,
+}
+
+// ParseExtensions turns a comma separated list of extension names into
+// parser flags. A name prefixed with "-" turns that extension off, so
+// "common,-tables" is the common set without tables.
+func ParseExtensions(list string) (parser.Extensions, error) {
+	var extensions parser.Extensions
+	for _, name := range splitList(list) {
+		remove := strings.HasPrefix(name, "-")
+		ext, ok := markdownExtensionNames[strings.TrimPrefix(name, "-")]
+		if !ok {
+			return 0, fmt.Errorf("unknown markdown extension %q", strings.TrimPrefix(name, "-"))
+		}
+		if remove {
+			extensions &^= ext
+		} else {
+			extensions |= ext
+		}
+	}
+	return extensions, nil
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
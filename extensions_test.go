@@ -0,0 +1,40 @@
+package thoughts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseExtensions(t *testing.T) {
+	if _, err := ParseExtensions("common,tabels"); err == nil || !strings.Contains(err.Error(), `unknown markdown extension "tabels"`) {
+		t.Errorf("expected an unknown extension error, got %v", err)
+	}
+	if _, err := ParseExtensions("-nope"); err == nil {
+		t.Error("expected an error for removing an unknown extension")
+	}
+
+	table := "| a |\n|---|\n| b |\n"
+	withTables, err := ParseExtensions(DefaultExtensions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutTables, err := ParseExtensions(DefaultExtensions + ",-tables")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out := renderString(t, table, RenderOptions{Extensions: withTables}); !strings.Contains(out, "<table>") {
+		t.Errorf("expected a table by default, got %s", out)
+	}
+	if out := renderString(t, table, RenderOptions{Extensions: withoutTables}); strings.Contains(out, "<table>") {
+		t.Errorf("expected no table with -tables, got %s", out)
+	}
+
+	hardBreaks, err := ParseExtensions(DefaultExtensions + ",hard-line-break")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := renderString(t, "one\ntwo", RenderOptions{Extensions: hardBreaks}); !strings.Contains(out, "one<br>") {
+		t.Errorf("expected a hard line break, got %s", out)
+	}
+}
@@ -0,0 +1,57 @@
+package thoughts
+
+import (
+	_ "embed"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// defaultFavicon is served when no -favicon is given, so browsers don't
+// log a 404 on every first visit.
+//
+//go:embed favicon.ico
+var defaultFavicon []byte
+
+// favicon is the icon served at /favicon.ico.
+type favicon struct {
+	data        []byte
+	contentType string
+}
+
+// loadFavicon reads the icon at path, or returns the built-in one when path
+// is empty.
+func loadFavicon(path string) (favicon, error) {
+	if path == "" {
+		return favicon{data: defaultFavicon, contentType: "image/x-icon"}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return favicon{}, fmt.Errorf("failed to read favicon %s: %w", path, err)
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" || filepath.Ext(path) == ".ico" {
+		contentType = "image/x-icon"
+	}
+	return favicon{data: data, contentType: contentType}, nil
+}
+
+// icon is the site's favicon, falling back to the built-in one.
+func (s *Site) icon() favicon {
+	if s.favicon.data == nil {
+		return favicon{data: defaultFavicon, contentType: "image/x-icon"}
+	}
+	return s.favicon
+}
+
+func (s *Site) serveFavicon(w http.ResponseWriter, r *http.Request) {
+	icon := s.icon()
+
+	w.Header().Set("Content-Type", icon.contentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	_, _ = w.Write(icon.data)
+}
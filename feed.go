@@ -0,0 +1,120 @@
+package thoughts
+
+import (
+	"encoding/xml"
+	"net/http"
+	"time"
+)
+
+// feedSize is how many of the newest notes a feed lists.
+const feedSize = 20
+
+// feedEntry is one note in a feed, shared by every feed format so they
+// list the same notes the same way.
+type feedEntry struct {
+	Title   string
+	URL     string
+	Updated time.Time
+	Summary string
+}
+
+// feedEntries lists the newest dated documents first, with URLs made
+// absolute against origin.
+func (s *Site) feedEntries(origin string) []feedEntry {
+	recent := s.activeRepo().Recent(feedSize)
+	entries := make([]feedEntry, 0, len(recent))
+	for _, d := range recent {
+		entries = append(entries, feedEntry{
+			Title:   d.Title(),
+			URL:     origin + d.URL(),
+			Updated: d.Date(),
+			Summary: d.Excerpt(),
+		})
+	}
+	return entries
+}
+
+// feedOrigin is the scheme and host feeds and llms.txt build absolute URLs
+// from: the site's base URL, or the host the request came in on.
+func (s *Site) feedOrigin(r *http.Request) string {
+	if s.baseURL != "" || r == nil {
+		return s.baseURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary,omitempty"`
+}
+
+// atomFeed builds the Atom feed. It is updated when the newest note was,
+// or at the last sync when there are no dated notes.
+func (s *Site) atomFeed(origin string) ([]byte, error) {
+	entries := s.feedEntries(origin)
+
+	updated := s.activeRepo().SyncedAt()
+	if len(entries) > 0 {
+		updated = entries[0].Updated
+	}
+
+	home := origin + s.basePath + "/"
+	feed := atomFeed{
+		Title:   s.siteTitle(),
+		ID:      home,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: origin + s.basePath + "/feed.atom", Rel: "self", Type: "application/atom+xml"},
+			{Href: home, Rel: "alternate", Type: "text/html"},
+		},
+	}
+	for _, e := range entries {
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   e.Title,
+			ID:      e.URL,
+			Updated: e.Updated.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: e.URL, Rel: "alternate", Type: "text/html"},
+			Summary: e.Summary,
+		})
+	}
+
+	b, err := xml.MarshalIndent(feed, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+func (s *Site) serveAtom(w http.ResponseWriter, r *http.Request) {
+	b, err := s.atomFeed(s.feedOrigin(r))
+	if err != nil {
+		s.logger.Printf("failed to build atom feed: %v\n", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	_, _ = w.Write(b)
+}
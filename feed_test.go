@@ -0,0 +1,76 @@
+package thoughts
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeAtom(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md":              "# Index",
+		"about.md":               "# About",
+		"thoughts/2024-03-15.md": "# March\n\nSpring notes.",
+		"thoughts/2023-12-31.md": "# December",
+		"thoughts/2024-01-02.md": "# January",
+	})
+	s.baseURL = "https://example.com"
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/feed.atom", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/atom+xml") {
+		t.Errorf("expected an atom content type, got %q", ct)
+	}
+
+	var feed struct {
+		XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+		ID      string   `xml:"id"`
+		Updated string   `xml:"updated"`
+		Entries []struct {
+			Title   string `xml:"title"`
+			ID      string `xml:"id"`
+			Updated string `xml:"updated"`
+			Summary string `xml:"summary"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("expected a valid atom feed: %v\n%s", err, rec.Body.String())
+	}
+
+	if feed.ID != "https://example.com/" {
+		t.Errorf("expected the site as the feed id, got %q", feed.ID)
+	}
+	if feed.Updated != "2024-03-15T00:00:00Z" {
+		t.Errorf("expected the feed updated with the newest note, got %q", feed.Updated)
+	}
+
+	var ids []string
+	for _, e := range feed.Entries {
+		ids = append(ids, e.ID)
+	}
+	want := "https://example.com/thoughts/2024-03-15,https://example.com/thoughts/2024-01-02,https://example.com/thoughts/2023-12-31"
+	if got := strings.Join(ids, ","); got != want {
+		t.Errorf("expected entries %s, got %s", want, got)
+	}
+	if e := feed.Entries[0]; e.Title != "March" || e.Updated != "2024-03-15T00:00:00Z" || e.Summary != "Spring notes." {
+		t.Errorf("unexpected first entry %+v", e)
+	}
+}
+
+func TestServeAtomUsesRequestHost(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md":              "# Index",
+		"thoughts/2024-03-15.md": "# March",
+	})
+
+	body := get(t, s, "/feed.atom")
+	if !strings.Contains(body, "<id>http://example.com/thoughts/2024-03-15</id>") {
+		t.Errorf("expected entry ids from the request host, got %s", body)
+	}
+	if page := get(t, s, "/"); !strings.Contains(page, `type="application/atom+xml"`) {
+		t.Error("expected pages to link to the feed")
+	}
+}
@@ -1,8 +1,7 @@
-package main
+package thoughts
 
 import (
 	"archive/zip"
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -10,122 +9,449 @@ import (
 	"io"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
 const githubAPI = "https://api.github.com"
 
-type githubClient struct {
+// errArchiveTooLarge is returned when a zipball, or a file inside it, is
+// bigger than the configured maximum.
+var errArchiveTooLarge = errors.New("archive too large")
+
+// errCorruptArchive is returned when a zipball can't be read back, usually
+// because the download was cut short. Syncs download it again right away
+// rather than waiting for the next attempt.
+var errCorruptArchive = errors.New("corrupt archive")
+
+// archiveClient fetches JSON and zip archives of a repo over HTTP, for the
+// providers whose hosts serve both.
+type archiveClient struct {
 	logger *log.Logger
+
+	// client makes the API calls, which are small enough for a short
+	// overall timeout. downloadClient fetches archives, which can take
+	// far longer, so it only bounds connecting and waiting for the
+	// response headers and leaves the body to the context.
+	client         *http.Client
+	downloadClient *http.Client
+
+	// header is sent with every request.
+	header http.Header
+
+	// tempDir is where zipballs are downloaded to, the OS default if empty.
+	tempDir string
+
+	// maxZipSize caps the size of the downloaded zipball and of each file
+	// extracted from it. Zero means no limit.
+	maxZipSize int64
+
+	// downloadRetries is how many more times a zipball download that
+	// fails on the network or with a server error is started over.
+	// retryDelay is the wait before the first retry, doubling after each.
+	downloadRetries int
+	retryDelay      time.Duration
+}
+
+func newArchiveClient(logger *log.Logger, header http.Header) archiveClient {
+	header.Set("User-Agent", "thoughts-agent")
+	return archiveClient{
+		logger:         logger,
+		client:         &http.Client{Timeout: 5 * time.Second},
+		downloadClient: newDownloadClient(),
+		header:         header,
+	}
+}
+
+// newDownloadClient creates a client with no overall timeout, for
+// downloads whose size makes any fixed limit wrong.
+func newDownloadClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	return &http.Client{Transport: transport}
+}
+
+type githubClient struct {
+	archiveClient
 	apiURL string
-	client *http.Client
 	owner  string
 	name   string
+
+	// activityETag and activityModified validate the last activity
+	// response, which held activityHash. They are sent back so that a
+	// quiet repo's feed costs a 304 instead of a body.
+	activityMu       sync.Mutex
+	activityETag     string
+	activityModified string
+	activityHash     string
 }
 
 func newGitHubClient(logger *log.Logger, apiURL, repoURL string) (*githubClient, error) {
-	u, err := url.Parse(repoURL)
+	owner, name, err := parseRepoURL(repoURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse url: %w", err)
+		return nil, err
 	}
 
-	p := strings.Split(u.Path, "/")
-	if len(p) != 3 {
-		return nil, errors.New("invalid repo url, should be just github.com/{owner}/{name}")
+	logger.Printf("nwo: %s/%s\n", owner, name)
+	return &githubClient{
+		archiveClient: newArchiveClient(logger, http.Header{"Accept": {"application/vnd.github.v3+json"}}),
+		apiURL:        apiURL,
+		owner:         owner,
+		name:          name,
+	}, nil
+}
+
+// parseRepoURL extracts the owner and name from a GitHub repo reference.
+// It accepts full URLs, URLs without a scheme and bare owner/name pairs,
+// with or without a trailing slash or ".git".
+func parseRepoURL(repoURL string) (owner, name string, err error) {
+	raw := strings.TrimSpace(repoURL)
+	if raw == "" {
+		return "", "", errors.New("invalid repo url: empty")
 	}
 
-	client := &http.Client{
-		Timeout: 5 * time.Second,
+	if !strings.Contains(raw, "://") {
+		// Hosts have dots and GitHub owners cannot, so a dotless first
+		// segment is a bare owner/name.
+		if first, _, _ := strings.Cut(strings.TrimPrefix(raw, "/"), "/"); !strings.Contains(first, ".") {
+			raw = "github.com/" + raw
+		}
+		raw = "https://" + raw
 	}
 
-	logger.Printf("nwo: %s/%s\n", p[1], p[2])
-	return &githubClient{
-		logger: logger,
-		apiURL: apiURL,
-		client: client,
-		owner:  p[1],
-		name:   p[2],
-	}, nil
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid repo url %q: %w", repoURL, err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", "", fmt.Errorf("invalid repo url %q: unsupported scheme %q", repoURL, u.Scheme)
+	}
+	if host := strings.TrimPrefix(u.Hostname(), "www."); host != "github.com" {
+		return "", "", fmt.Errorf("invalid repo url %q: host %q is not github.com", repoURL, u.Host)
+	}
+
+	p := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	segments := strings.Split(p, "/")
+	switch {
+	case p == "":
+		return "", "", fmt.Errorf("invalid repo url %q: missing owner and name", repoURL)
+	case strings.Contains(p, "//"):
+		return "", "", fmt.Errorf("invalid repo url %q: empty path segment", repoURL)
+	case len(segments) == 1:
+		return "", "", fmt.Errorf("invalid repo url %q: missing repo name after owner %q", repoURL, segments[0])
+	case len(segments) > 2:
+		return "", "", fmt.Errorf("invalid repo url %q: unexpected path %q after owner/name", repoURL, strings.Join(segments[2:], "/"))
+	}
+
+	return segments[0], segments[1], nil
 }
 
 func (g *githubClient) LastHash(ctx context.Context) (string, error) {
 	activityURL := fmt.Sprintf("%s/repos/%s/%s/activity", g.apiURL, g.owner, g.name)
-	req, err := http.NewRequestWithContext(ctx, "GET", activityURL, nil)
+	g.logger.Printf("getting last hash %s\n", activityURL)
+
+	g.activityMu.Lock()
+	defer g.activityMu.Unlock()
+
+	var activity []struct {
+		After string `json:"after"`
+	}
+	header, modified, err := g.getJSONIfModified(ctx, activityURL, &activity, g.activityETag, g.activityModified)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return "", err
+	}
+	if !modified {
+		g.logger.Printf("activity not modified, last hash is %s\n", g.activityHash)
+		return g.activityHash, nil
+	}
+	if len(activity) > 0 {
+		g.activityETag = header.Get("ETag")
+		g.activityModified = header.Get("Last-Modified")
+		g.activityHash = activity[0].After
+		g.logger.Printf("last hash is %s\n", activity[0].After)
+		return activity[0].After, nil
 	}
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
-	req.Header.Set("User-Agent", "thoughts-agent")
+	g.activityETag, g.activityModified, g.activityHash = "", "", ""
 
-	g.logger.Printf("getting last hash %s\n", activityURL)
-	resp, err := g.client.Do(req)
+	// The activity feed can come back empty for a quiet repo that has
+	// commits, so ask for the branch's latest commit instead.
+	commitsURL := fmt.Sprintf("%s/repos/%s/%s/commits?sha=main&per_page=1", g.apiURL, g.owner, g.name)
+	g.logger.Printf("no activity found, getting last hash %s\n", commitsURL)
+
+	var commits []struct {
+		SHA string `json:"sha"`
+	}
+	if err := g.getJSON(ctx, commitsURL, &commits); err != nil {
+		return "", err
+	}
+	if len(commits) == 0 {
+		return "", errors.New("no commits found, must commit to the repo before using the agent")
+	}
+
+	g.logger.Printf("last hash is %s\n", commits[0].SHA)
+	return commits[0].SHA, nil
+}
+
+// newRequest creates a GET request for url carrying the client's headers.
+func (a *archiveClient) newRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range a.header {
+		req.Header[k] = v
+	}
+	return req, nil
+}
+
+// getJSON decodes the JSON response to a GET of url into v.
+func (a *archiveClient) getJSON(ctx context.Context, url string, v any) error {
+	_, _, err := a.getJSONIfModified(ctx, url, v, "", "")
+	return err
+}
+
+// getJSONIfModified is getJSON made conditional on the ETag and
+// Last-Modified of an earlier response, either of which may be empty. It
+// reports whether the response was modified, leaving v alone when it
+// wasn't, and returns its headers.
+func (a *archiveClient) getJSONIfModified(ctx context.Context, url string, v any, etag, lastModified string) (http.Header, bool, error) {
+	req, err := a.newRequest(ctx, url)
 	if err != nil {
-		return "", fmt.Errorf("failed to do request: %w", err)
+		return nil, false, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to do request: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && (etag != "" || lastModified != "") {
+		return resp.Header, false, nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return nil, false, fmt.Errorf("failed to read response: %w", err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil, false, fmt.Errorf("failed to decode response: %w", err)
 	}
+	return resp.Header, true, nil
+}
 
-	var response []struct {
-		After string `json:"after"`
+// transientError marks a download failure worth starting over for.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+func (g *githubClient) Contents(ctx context.Context) (fs.FS, func(), error) {
+	zipURL := fmt.Sprintf("%s/repos/%s/%s/zipball/main", g.apiURL, g.owner, g.name)
+	return g.fetchArchive(ctx, zipURL)
+}
+
+// fetchArchive downloads the zip at zipURL, retrying transient failures,
+// and opens it once its checksums are verified. The returned func removes
+// the download.
+func (a *archiveClient) fetchArchive(ctx context.Context, zipURL string) (fs.FS, func(), error) {
+	delay := a.retryDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	var name string
+	for attempt := 0; ; attempt++ {
+		var err error
+		name, err = a.download(ctx, zipURL)
+		if err == nil {
+			break
+		}
+
+		var transient *transientError
+		if !errors.As(err, &transient) || attempt >= a.downloadRetries || ctx.Err() != nil {
+			return nil, nil, err
+		}
+		a.logger.Printf("zipball download failed, retrying in %s: %v\n", delay, err)
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+
+	r, err := zip.OpenReader(name)
+	if err != nil {
+		os.Remove(name)
+		return nil, nil, fmt.Errorf("failed to open zip reader: %w: %v", errCorruptArchive, err)
 	}
-	if err := json.Unmarshal(b, &response); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+	if err := verifyArchive(&r.Reader, a.maxZipSize); err != nil {
+		r.Close()
+		os.Remove(name)
+		return nil, nil, err
 	}
 
-	if len(response) == 0 {
-		return "", errors.New("no activity found, must commit to the repo before using the agent")
+	var zipFS fs.FS = r
+	if a.maxZipSize > 0 {
+		zipFS = &limitedFS{FS: r, max: a.maxZipSize}
 	}
 
-	g.logger.Printf("last hash is %s\n", response[0].After)
-	return response[0].After, nil
+	return zipFS, func() {
+		r.Close()
+		os.Remove(name)
+	}, nil
 }
 
-func (g *githubClient) Contents(ctx context.Context) (fs.FS, func(), error) {
-	zipURL := fmt.Sprintf("%s/repos/%s/%s/zipball/main", g.apiURL, g.owner, g.name)
-	req, err := http.NewRequestWithContext(ctx, "GET", zipURL, nil)
+// download fetches the zipball at zipURL into a temp file and returns its
+// name. Network errors and server errors come back as transientError.
+func (a *archiveClient) download(ctx context.Context, zipURL string) (string, error) {
+	req, err := a.newRequest(ctx, zipURL)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create request: %w", err)
+		return "", err
 	}
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
-	req.Header.Set("User-Agent", "thoughts-agent")
 
-	g.logger.Printf("getting zipball %s\n", zipURL)
-	resp, err := g.client.Do(req)
+	a.logger.Printf("getting zipball %s\n", zipURL)
+	resp, err := a.downloadClient.Do(req)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to do request: %w", err)
+		return "", &transientError{fmt.Errorf("failed to do request: %w", err)}
 	}
+	defer resp.Body.Close()
 
+	if resp.StatusCode >= 500 {
+		return "", &transientError{fmt.Errorf("unexpected status code: %d", resp.StatusCode)}
+	}
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusFound {
-		return nil, nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	b, err := io.ReadAll(resp.Body)
+	// Stream the archive to disk rather than holding it in memory, it is
+	// read lazily from there.
+	f, err := os.CreateTemp(a.tempDir, "thoughts-zipball-*.zip")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	var body io.Reader = resp.Body
+	if a.maxZipSize > 0 {
+		body = io.LimitReader(resp.Body, a.maxZipSize+1)
+	}
+	n, err := io.Copy(f, body)
+	if err != nil {
+		err = &transientError{err}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && a.maxZipSize > 0 && n > a.maxZipSize {
+		err = fmt.Errorf("%w: more than %d bytes", errArchiveTooLarge, a.maxZipSize)
+	}
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read response: %w", err)
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to download zipball: %w", err)
+	}
+
+	a.logger.Printf("zipball is %d bytes\n", n)
+	return f.Name(), nil
+}
+
+// verifyArchive reads every file in the archive through to the end, which
+// checks its CRC, so a damaged download fails here rather than halfway
+// through extracting documents. Files over max are left for limitedFS to
+// refuse.
+func verifyArchive(r *zip.Reader, max int64) error {
+	for _, zf := range r.File {
+		if zf.FileInfo().IsDir() || (max > 0 && zf.UncompressedSize64 > uint64(max)) {
+			continue
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %w: %v", zf.Name, errCorruptArchive, err)
+		}
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w: %v", zf.Name, errCorruptArchive, err)
+		}
 	}
+	return nil
+}
 
-	g.logger.Printf("zipball is %d bytes\n", len(b))
-	r, err := zip.NewReader(bytes.NewReader(b), resp.ContentLength)
+// limitedFS refuses to read more than max bytes from any one file, so a
+// small archive can't decompress into something huge.
+type limitedFS struct {
+	fs.FS
+	max int64
+}
+
+func (l *limitedFS) Open(name string) (fs.File, error) {
+	f, err := l.FS.Open(name)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create zip reader: %w", err)
+		return nil, err
 	}
 
-	return r, func() {
-		resp.Body.Close()
-	}, nil
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !info.IsDir() && info.Size() > l.max {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w: more than %d bytes", name, errArchiveTooLarge, l.max)
+	}
+
+	return &limitedFile{File: f, name: name, remaining: l.max}, nil
+}
+
+type limitedFile struct {
+	fs.File
+	name      string
+	remaining int64
+}
+
+func (f *limitedFile) Read(p []byte) (int, error) {
+	if f.remaining <= 0 {
+		// Only an error if the file actually has more to give.
+		n, err := f.File.Read(make([]byte, 1))
+		if n > 0 {
+			return 0, fmt.Errorf("%s: %w", f.name, errArchiveTooLarge)
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > f.remaining {
+		p = p[:f.remaining]
+	}
+	n, err := f.File.Read(p)
+	f.remaining -= int64(n)
+	return n, err
+}
+
+// ReadDir keeps directory listings working through the wrapper.
+func (f *limitedFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	d, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: errors.New("not a directory")}
+	}
+	return d.ReadDir(n)
 }
 
 const cacheDir = "cache"
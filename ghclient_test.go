@@ -1,32 +1,42 @@
-package main
+package thoughts
 
 import (
 	"archive/zip"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"testing/fstest"
+	"time"
 )
 
 func createTestTar(t *testing.T) (string, func()) {
 	t.Helper()
 
-	tmpfile, err := ioutil.TempFile(os.TempDir(), "thoughts-agent-test-")
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	fileFS := fstest.MapFS{
+	return createTestZip(t, fstest.MapFS{
 		"README.md": &fstest.MapFile{
 			Data: []byte("Hello, World!"),
 		},
 		"thoughts/2022-01-01.md": &fstest.MapFile{
 			Data: []byte("Hello, 2022-01-01!"),
 		},
+	})
+}
+
+func createTestZip(t *testing.T, fileFS fstest.MapFS) (string, func()) {
+	t.Helper()
+
+	tmpfile, err := ioutil.TempFile(os.TempDir(), "thoughts-agent-test-")
+	if err != nil {
+		t.Fatal(err)
 	}
 
 	zw := zip.NewWriter(tmpfile)
@@ -55,16 +65,403 @@ func TestGithubClientContents(t *testing.T) {
 		http.Redirect(w, r, tarsvr.URL, http.StatusFound)
 	}))
 
-	ghclient, err := NewGitHubClient(svr.URL, "https://github.com/josebalius/thoughts")
+	ghclient, err := newGitHubClient(log.New(io.Discard, "", 0), svr.URL, "https://github.com/josebalius/thoughts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ghclient.tempDir = t.TempDir()
+
+	contents, cleanup, err := ghclient.Contents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := fs.ReadFile(contents, "thoughts/2022-01-01.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello, 2022-01-01!" {
+		t.Fatalf("unexpected contents %q", b)
+	}
+
+	cleanup()
+
+	entries, err := os.ReadDir(ghclient.tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected the downloaded zipball to be removed, found %v", entries)
+	}
+}
+
+func newZipServer(t *testing.T, zipfile string) *httptest.Server {
+	t.Helper()
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, zipfile)
+	}))
+	t.Cleanup(svr.Close)
+	return svr
+}
+
+func TestGithubClientContentsSlowDownload(t *testing.T) {
+	zipfile, cleanup := createTestTar(t)
+	defer cleanup()
+	b, err := os.ReadFile(zipfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The body trickles in for longer than the API timeout allows.
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		half := len(b) / 2
+		w.Write(b[:half])
+		w.(http.Flusher).Flush()
+		time.Sleep(300 * time.Millisecond)
+		w.Write(b[half:])
+	}))
+	defer svr.Close()
+
+	ghclient, err := newGitHubClient(log.New(io.Discard, "", 0), svr.URL, "josebalius/thoughts")
 	if err != nil {
 		t.Fatal(err)
 	}
+	ghclient.tempDir = t.TempDir()
+	ghclient.client.Timeout = 100 * time.Millisecond
 
 	contents, cleanup, err := ghclient.Contents(context.Background())
+	if err != nil {
+		t.Fatalf("expected a slow download to finish, got %v", err)
+	}
+	defer cleanup()
+	if _, err := fs.ReadFile(contents, "thoughts/2022-01-01.md"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGithubClientContentsMaxZipSize(t *testing.T) {
+	zipfile, cleanup := createTestTar(t)
+	defer cleanup()
+
+	svr := newZipServer(t, zipfile)
+	ghclient, err := newGitHubClient(log.New(io.Discard, "", 0), svr.URL, "https://github.com/josebalius/thoughts")
 	if err != nil {
 		t.Fatal(err)
 	}
+	ghclient.tempDir = t.TempDir()
+	ghclient.maxZipSize = 10
+
+	_, _, err = ghclient.Contents(context.Background())
+	if !errors.Is(err, errArchiveTooLarge) {
+		t.Fatalf("expected errArchiveTooLarge, got %v", err)
+	}
+
+	entries, _ := os.ReadDir(ghclient.tempDir)
+	if len(entries) != 0 {
+		t.Fatalf("expected the partial download to be removed, found %v", entries)
+	}
+}
+
+func TestGithubClientContentsMaxFileSize(t *testing.T) {
+	// Zeros compress well, so the archive is far smaller than the file.
+	zipfile, cleanup := createTestZip(t, fstest.MapFS{
+		"README.md": &fstest.MapFile{Data: []byte("Hello, World!")},
+		"bomb.md":   &fstest.MapFile{Data: make([]byte, 1<<20)},
+	})
 	defer cleanup()
 
-	fmt.Println(contents)
+	svr := newZipServer(t, zipfile)
+	ghclient, err := newGitHubClient(log.New(io.Discard, "", 0), svr.URL, "https://github.com/josebalius/thoughts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ghclient.tempDir = t.TempDir()
+	ghclient.maxZipSize = 64 << 10
+
+	contents, cleanupContents, err := ghclient.Contents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanupContents()
+
+	if _, err := fs.ReadFile(contents, "README.md"); err != nil {
+		t.Fatalf("expected small files to be readable, got %v", err)
+	}
+	if _, err := fs.ReadFile(contents, "bomb.md"); !errors.Is(err, errArchiveTooLarge) {
+		t.Fatalf("expected errArchiveTooLarge, got %v", err)
+	}
+
+	// A file that lies about its size is still cut off while reading.
+	lying := &limitedFS{FS: fstest.MapFS{"a.md": &fstest.MapFile{Data: make([]byte, 100)}}, max: 10}
+	f, err := lying.Open("a.md")
+	if err == nil {
+		defer f.Close()
+		_, err = io.ReadAll(f)
+	}
+	if !errors.Is(err, errArchiveTooLarge) {
+		t.Fatalf("expected errArchiveTooLarge, got %v", err)
+	}
+}
+
+func TestGithubClientContentsCorruptArchive(t *testing.T) {
+	zipfile, cleanup := createTestZip(t, fstest.MapFS{
+		"README.md": &fstest.MapFile{Data: []byte(strings.Repeat("Hello, World! ", 100))},
+	})
+	defer cleanup()
+
+	valid, err := os.ReadFile(zipfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A flipped byte in the stored file leaves the central directory intact,
+	// so only the checksum catches it.
+	flipped := append([]byte(nil), valid...)
+	flipped[60] ^= 0xff
+
+	tests := map[string][]byte{
+		"truncated": valid[:len(valid)/2],
+		"bad crc":   flipped,
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(data)
+			}))
+			defer svr.Close()
+
+			ghclient, err := newGitHubClient(log.New(io.Discard, "", 0), svr.URL, "https://github.com/josebalius/thoughts")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ghclient.tempDir = t.TempDir()
+
+			_, _, err = ghclient.Contents(context.Background())
+			if !errors.Is(err, errCorruptArchive) {
+				t.Fatalf("expected errCorruptArchive, got %v", err)
+			}
+
+			entries, _ := os.ReadDir(ghclient.tempDir)
+			if len(entries) != 0 {
+				t.Fatalf("expected the corrupt download to be removed, found %v", entries)
+			}
+		})
+	}
+}
+
+func TestGithubClientContentsRetries(t *testing.T) {
+	zipfile, cleanup := createTestTar(t)
+	defer cleanup()
+
+	var calls int
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			// Drop the connection without a response, as a reset would.
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Close()
+			return
+		}
+		http.ServeFile(w, r, zipfile)
+	}))
+	defer svr.Close()
+
+	ghclient, err := newGitHubClient(log.New(io.Discard, "", 0), svr.URL, "https://github.com/josebalius/thoughts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ghclient.tempDir = t.TempDir()
+	ghclient.downloadRetries = 2
+	ghclient.retryDelay = time.Millisecond
+
+	contents, cleanupContents, err := ghclient.Contents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanupContents()
+
+	if calls != 2 {
+		t.Fatalf("expected 2 requests, got %d", calls)
+	}
+	if _, err := fs.ReadFile(contents, "README.md"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGithubClientContentsRetryStatus(t *testing.T) {
+	tests := map[string]struct {
+		status int
+		calls  int
+	}{
+		"server error": {http.StatusBadGateway, 3},
+		"not found":    {http.StatusNotFound, 1},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var calls int
+			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.WriteHeader(tc.status)
+			}))
+			defer svr.Close()
+
+			ghclient, err := newGitHubClient(log.New(io.Discard, "", 0), svr.URL, "https://github.com/josebalius/thoughts")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ghclient.tempDir = t.TempDir()
+			ghclient.downloadRetries = 2
+			ghclient.retryDelay = time.Millisecond
+
+			if _, _, err := ghclient.Contents(context.Background()); err == nil {
+				t.Fatal("expected an error")
+			}
+			if calls != tc.calls {
+				t.Fatalf("expected %d requests, got %d", tc.calls, calls)
+			}
+		})
+	}
+}
+
+func TestParseRepoURL(t *testing.T) {
+	tests := []struct {
+		in          string
+		owner, name string
+		err         string
+	}{
+		{in: "https://github.com/josebalius/thoughts", owner: "josebalius", name: "thoughts"},
+		{in: "https://github.com/josebalius/thoughts/", owner: "josebalius", name: "thoughts"},
+		{in: "https://github.com/josebalius/thoughts.git", owner: "josebalius", name: "thoughts"},
+		{in: "http://www.github.com/josebalius/thoughts", owner: "josebalius", name: "thoughts"},
+		{in: "github.com/josebalius/thoughts", owner: "josebalius", name: "thoughts"},
+		{in: "josebalius/thoughts", owner: "josebalius", name: "thoughts"},
+		{in: " josebalius/josebalius.github.io.git ", owner: "josebalius", name: "josebalius.github.io"},
+		{in: "", err: "empty"},
+		{in: "josebalius", err: `missing repo name after owner "josebalius"`},
+		{in: "https://github.com/", err: "missing owner and name"},
+		{in: "https://github.com/josebalius/thoughts/tree/main", err: `unexpected path "tree/main"`},
+		{in: "https://gitlab.com/josebalius/thoughts", err: `host "gitlab.com" is not github.com`},
+		{in: "ssh://github.com/josebalius/thoughts", err: `unsupported scheme "ssh"`},
+		{in: "https://github.com/josebalius//thoughts", err: "empty path segment"},
+	}
+
+	for _, tt := range tests {
+		owner, name, err := parseRepoURL(tt.in)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseRepoURL(%q): expected error containing %q, got %v", tt.in, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRepoURL(%q): unexpected error: %v", tt.in, err)
+			continue
+		}
+		if owner != tt.owner || name != tt.name {
+			t.Errorf("parseRepoURL(%q) = %s/%s, expected %s/%s", tt.in, owner, name, tt.owner, tt.name)
+		}
+	}
+}
+
+func TestGithubClientLastHash(t *testing.T) {
+	tests := map[string]struct {
+		activity string
+		commits  string
+		want     string
+		wantErr  string
+	}{
+		"activity":       {activity: `[{"after": "abc123"}, {"after": "older"}]`, want: "abc123"},
+		"empty activity": {activity: `[]`, commits: `[{"sha": "def456"}]`, want: "def456"},
+		"no commits":     {activity: `[]`, commits: `[]`, wantErr: "no commits found"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var commitsQuery string
+			mux := http.NewServeMux()
+			mux.HandleFunc("/repos/josebalius/thoughts/activity", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tc.activity))
+			})
+			mux.HandleFunc("/repos/josebalius/thoughts/commits", func(w http.ResponseWriter, r *http.Request) {
+				commitsQuery = r.URL.RawQuery
+				w.Write([]byte(tc.commits))
+			})
+			svr := httptest.NewServer(mux)
+			defer svr.Close()
+
+			ghclient, err := newGitHubClient(log.New(io.Discard, "", 0), svr.URL, "https://github.com/josebalius/thoughts")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ghclient.LastHash(context.Background())
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("expected hash %q, got %q", tc.want, got)
+			}
+			if tc.commits != "" && commitsQuery != "sha=main&per_page=1" {
+				t.Errorf("expected the latest commit on main to be asked for, got %q", commitsQuery)
+			}
+		})
+	}
+}
+
+func TestGithubClientLastHashNotModified(t *testing.T) {
+	var requests int
+	var gotETag, gotSince string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/josebalius/thoughts/activity", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		gotETag = r.Header.Get("If-None-Match")
+		gotSince = r.Header.Get("If-Modified-Since")
+		if gotETag == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 12 Oct 2026 10:00:00 GMT")
+		w.Write([]byte(`[{"after": "abc123"}]`))
+	})
+	mux.HandleFunc("/repos/josebalius/thoughts/commits", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected a 304 not to fall back to the commits API")
+	})
+	svr := httptest.NewServer(mux)
+	defer svr.Close()
+
+	ghclient, err := newGitHubClient(log.New(io.Discard, "", 0), svr.URL, "https://github.com/josebalius/thoughts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := ghclient.LastHash(context.Background()); err != nil || got != "abc123" {
+		t.Fatalf("expected hash abc123, got %q, %v", got, err)
+	}
+	if gotETag != "" || gotSince != "" {
+		t.Errorf("expected the first request to be unconditional, got %q %q", gotETag, gotSince)
+	}
+
+	got, err := ghclient.LastHash(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "abc123" {
+		t.Errorf("expected the cached hash abc123, got %q", got)
+	}
+	if requests != 2 || gotSince != "Mon, 12 Oct 2026 10:00:00 GMT" {
+		t.Errorf("expected a conditional second request, got %d requests since %q", requests, gotSince)
+	}
 }
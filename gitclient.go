@@ -0,0 +1,324 @@
+package thoughts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+// gitClient reads a repo from any git remote by cloning it, for self-hosted
+// and SSH-only repos that serve no archives.
+//
+// The clone in dir is bare and only ever fetched into, so a sync pulling a
+// new hash can't change the files of a checkout another buffer is reading.
+// Each Contents call checks the hash out into a directory of its own.
+type gitClient struct {
+	logger *log.Logger
+	url    string
+	auth   transport.AuthMethod
+
+	// dir is where the repo is cloned to, in a temporary directory made
+	// on the first sync if empty.
+	dir string
+
+	// tempRoot is the temporary directory made for dir, which Close
+	// removes.
+	tempRoot string
+
+	// depth is how many commits to clone and fetch, 0 for all of them.
+	depth int
+
+	// tempDir is where checkouts and temporary clones go, the OS default
+	// if empty.
+	tempDir string
+
+	mu     sync.Mutex
+	repo   *git.Repository
+	branch plumbing.ReferenceName // the remote's default branch
+	hash   plumbing.Hash          // the branch's head at the last fetch
+}
+
+// gitAuth picks how to authenticate to repoURL. token, when set, is sent
+// as the password over HTTPS. sshKey, when set, is a private key file for
+// SSH remotes, which otherwise use the SSH agent.
+func gitAuth(repoURL, token, sshKey string) (transport.AuthMethod, error) {
+	ep, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repo url %q: %w", repoURL, err)
+	}
+
+	switch ep.Protocol {
+	case "http", "https":
+		if token == "" {
+			return nil, nil
+		}
+		user := ep.User
+		if user == "" {
+			// Hosts only check the token, but basic auth needs a user.
+			user = "git"
+		}
+		return &githttp.BasicAuth{Username: user, Password: token}, nil
+
+	case "ssh":
+		if sshKey == "" {
+			return nil, nil
+		}
+		user := ep.User
+		if user == "" {
+			user = "git"
+		}
+		auth, err := gitssh.NewPublicKeysFromFile(user, sshKey, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ssh key: %w", err)
+		}
+		return auth, nil
+	}
+	return nil, nil
+}
+
+// newGitClient creates a client for the repo at repoURL, cloned under
+// dir, or a temporary directory if dir is empty.
+func newGitClient(logger *log.Logger, repoURL, dir, token, sshKey string) (*gitClient, error) {
+	repoURL = strings.TrimSpace(repoURL)
+	if repoURL == "" {
+		return nil, errors.New("invalid repo url: empty")
+	}
+
+	auth, err := gitAuth(repoURL, token, sshKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if dir != "" {
+		dir = filepath.Join(dir, gitRepoName(repoURL))
+	}
+
+	logger.Printf("git repo: %s\n", repoURL)
+	return &gitClient{
+		logger: logger,
+		url:    repoURL,
+		auth:   auth,
+		dir:    dir,
+		depth:  1,
+	}, nil
+}
+
+// gitRepoName names the clone of repoURL, so that several repos can share
+// a clone dir.
+func gitRepoName(repoURL string) string {
+	name := slugify(strings.TrimSuffix(repoURL, ".git"))
+	if name == "" {
+		return "repo"
+	}
+	return name
+}
+
+// LastHash fetches the remote's default branch, cloning the repo first if
+// it isn't yet, and returns its head.
+func (g *gitClient) LastHash(ctx context.Context) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.repo == nil {
+		if err := g.open(ctx); err != nil {
+			return "", err
+		}
+	} else if err := g.fetch(ctx); err != nil {
+		return "", err
+	}
+
+	ref, err := g.repo.Reference(g.branch, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", g.branch.Short(), err)
+	}
+	g.hash = ref.Hash()
+
+	g.logger.Printf("last hash is %s\n", g.hash)
+	return g.hash.String(), nil
+}
+
+// open opens the clone left in dir by an earlier run, or clones the repo
+// into it.
+func (g *gitClient) open(ctx context.Context) error {
+	if g.dir == "" {
+		dir, err := os.MkdirTemp(g.tempDir, "thoughts-git-*")
+		if err != nil {
+			return fmt.Errorf("failed to create clone dir: %w", err)
+		}
+		g.tempRoot = dir
+		g.dir = filepath.Join(dir, gitRepoName(g.url))
+	}
+
+	repo, err := git.PlainOpen(g.dir)
+	switch {
+	case err == nil:
+		g.logger.Printf("opening clone in %s\n", g.dir)
+		if err := g.useRepo(repo); err != nil {
+			return err
+		}
+		return g.fetch(ctx)
+
+	case !errors.Is(err, git.ErrRepositoryNotExists):
+		return fmt.Errorf("failed to open clone: %w", err)
+	}
+
+	g.logger.Printf("cloning %s into %s\n", g.url, g.dir)
+	repo, err = git.PlainCloneContext(ctx, g.dir, true, &git.CloneOptions{
+		URL:          g.url,
+		Auth:         g.auth,
+		Depth:        g.depth,
+		SingleBranch: true,
+		Tags:         git.NoTags,
+	})
+	if err != nil {
+		if g.tempRoot != "" {
+			os.RemoveAll(g.tempRoot)
+			g.tempRoot, g.dir = "", ""
+		} else {
+			os.RemoveAll(g.dir)
+		}
+		if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+			return errors.New("no commits found, must commit to the repo before using the agent")
+		}
+		return fmt.Errorf("failed to clone: %w", err)
+	}
+	return g.useRepo(repo)
+}
+
+// Close removes the temporary clone, if the client made one. A clone in a
+// dir that was given is kept for the next run.
+func (g *gitClient) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.tempRoot == "" {
+		return nil
+	}
+	g.repo = nil
+	if err := os.RemoveAll(g.tempRoot); err != nil {
+		g.logger.Printf("failed to remove clone: %v\n", err)
+		return err
+	}
+	g.tempRoot, g.dir = "", ""
+	return nil
+}
+
+// useRepo reads the branch a clone tracks from its HEAD, which cloning
+// points at the remote's default branch.
+func (g *gitClient) useRepo(repo *git.Repository) error {
+	head, err := repo.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	if head.Type() != plumbing.SymbolicReference || !head.Target().IsBranch() {
+		return fmt.Errorf("clone in %s is not on a branch", g.dir)
+	}
+
+	g.repo = repo
+	g.branch = head.Target()
+	return nil
+}
+
+// fetch moves the clone's branch to the remote's, even when the remote's
+// history was rewritten.
+func (g *gitClient) fetch(ctx context.Context) error {
+	refSpec := config.RefSpec(fmt.Sprintf("+%s:%s", g.branch, g.branch))
+	err := g.repo.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: []config.RefSpec{refSpec},
+		Auth:     g.auth,
+		Depth:    g.depth,
+		Tags:     git.NoTags,
+		Force:    true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to fetch: %w", err)
+	}
+	return nil
+}
+
+// Contents checks out the hash LastHash last returned into a temporary
+// directory, which the returned func removes. Like an archive, the files
+// are wrapped in a single directory, which extracting strips, so a repo
+// with one top-level folder keeps it.
+func (g *gitClient) Contents(ctx context.Context) (fs.FS, func(), error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.repo == nil {
+		return nil, nil, errors.New("repo not cloned, get the last hash first")
+	}
+
+	commit, err := g.repo.CommitObject(g.hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read commit %s: %w", g.hash, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read tree of %s: %w", g.hash, err)
+	}
+
+	dir, err := os.MkdirTemp(g.tempDir, "thoughts-checkout-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create checkout dir: %w", err)
+	}
+	if err := checkoutTree(ctx, tree, filepath.Join(dir, "repo-"+g.hash.String())); err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+
+	return os.DirFS(dir), func() {
+		if err := os.RemoveAll(dir); err != nil {
+			g.logger.Printf("failed to remove checkout: %v\n", err)
+		}
+	}, nil
+}
+
+// checkoutTree writes the regular files of tree under dir, leaving out
+// symlinks and submodules.
+func checkoutTree(ctx context.Context, tree *object.Tree, dir string) error {
+	return tree.Files().ForEach(func(f *object.File) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if f.Mode != filemode.Regular && f.Mode != filemode.Executable {
+			return nil
+		}
+
+		name := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			return fmt.Errorf("failed to create dir for %s: %w", f.Name, err)
+		}
+
+		r, err := f.Reader()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", f.Name, err)
+		}
+		defer r.Close()
+
+		out, err := os.Create(name)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", f.Name, err)
+		}
+		if _, err := io.Copy(out, r); err != nil {
+			out.Close()
+			return fmt.Errorf("failed to write %s: %w", f.Name, err)
+		}
+		return out.Close()
+	})
+}
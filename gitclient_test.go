@@ -0,0 +1,205 @@
+package thoughts
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// newGitFixture creates a bare repo holding files, committed from a work
+// tree the returned func commits further changes from.
+func newGitFixture(t *testing.T, files map[string]string) (bare string, commit func(files map[string]string) string) {
+	t.Helper()
+
+	work := t.TempDir()
+	wrepo, err := git.PlainInit(work, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := wrepo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bare = filepath.Join(t.TempDir(), "notes.git")
+	commit = func(files map[string]string) string {
+		t.Helper()
+		for name, data := range files {
+			p := filepath.Join(work, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := wt.Add(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		hash, err := wt.Commit("update notes", &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(bare); err == nil {
+			if err := wrepo.Push(&git.PushOptions{RemoteName: "origin"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return hash.String()
+	}
+
+	commit(files)
+	if _, err := git.PlainClone(bare, true, &git.CloneOptions{URL: work}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrepo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{bare}}); err != nil {
+		t.Fatal(err)
+	}
+	return bare, commit
+}
+
+func TestGitClient(t *testing.T) {
+	bare, commit := newGitFixture(t, map[string]string{
+		"notes/README.md": "# Notes",
+	})
+
+	gclient, err := newGitClient(log.New(io.Discard, "", 0), bare, t.TempDir(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gclient.tempDir = t.TempDir()
+
+	head, err := git.PlainOpen(bare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := head.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := gclient.LastHash(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != ref.Hash().String() {
+		t.Errorf("expected hash %s, got %q", ref.Hash(), hash)
+	}
+
+	// A repo with a single top-level folder keeps it.
+	r := newRepo(log.New(io.Discard, "", 0), gclient, RenderOptions{})
+	if err := r.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.Document("notes"); !ok {
+		t.Errorf("expected notes to be served, got %v", r.Documents())
+	}
+	if entries, _ := os.ReadDir(gclient.tempDir); len(entries) != 0 {
+		t.Errorf("expected the checkout to be removed, found %v", entries)
+	}
+
+	want := commit(map[string]string{"ideas/a.md": "# Idea A"})
+	hash, err = gclient.LastHash(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != want {
+		t.Errorf("expected the pulled hash %s, got %q", want, hash)
+	}
+
+	contents, cleanup, err := gclient.Contents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	b, err := fs.ReadFile(contents, "repo-"+want+"/ideas/a.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "# Idea A" {
+		t.Errorf("unexpected contents %q", b)
+	}
+
+	// A restart picks the clone back up rather than cloning again.
+	reopened, err := newGitClient(log.New(io.Discard, "", 0), bare, filepath.Dir(gclient.dir), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := reopened.LastHash(context.Background()); err != nil || hash != want {
+		t.Errorf("expected the reopened clone at %s, got %q, %v", want, hash, err)
+	}
+}
+
+func TestGitClientTempClone(t *testing.T) {
+	bare, _ := newGitFixture(t, map[string]string{"README.md": "# Notes"})
+
+	// A failed clone removes the temporary dir made for it.
+	missing, err := newGitClient(log.New(io.Discard, "", 0), filepath.Join(t.TempDir(), "missing.git"), "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing.tempDir = t.TempDir()
+	if _, err := missing.LastHash(context.Background()); err == nil {
+		t.Fatal("expected cloning a missing repo to fail")
+	}
+	if entries, _ := os.ReadDir(missing.tempDir); len(entries) != 0 {
+		t.Errorf("expected the failed clone to be removed, found %v", entries)
+	}
+
+	gclient, err := newGitClient(log.New(io.Discard, "", 0), bare, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gclient.tempDir = t.TempDir()
+	if _, err := gclient.LastHash(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(gclient.tempDir); len(entries) != 1 {
+		t.Fatalf("expected a temporary clone, found %v", entries)
+	}
+
+	contents := newSharedContents(gclient)
+	contents.Close()
+	if entries, _ := os.ReadDir(gclient.tempDir); len(entries) != 0 {
+		t.Errorf("expected the temporary clone to be removed on close, found %v", entries)
+	}
+}
+
+func TestGitAuth(t *testing.T) {
+	auth, err := gitAuth("https://git.example.com/team/notes.git", "secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth == nil || !strings.Contains(auth.String(), "git") {
+		t.Errorf("expected basic auth with the token, got %v", auth)
+	}
+
+	if auth, err := gitAuth("https://git.example.com/team/notes.git", "", ""); err != nil || auth != nil {
+		t.Errorf("expected no auth without a token, got %v, %v", auth, err)
+	}
+	if _, err := gitAuth("git@git.example.com:team/notes.git", "", filepath.Join(t.TempDir(), "missing")); err == nil || !strings.Contains(err.Error(), "ssh key") {
+		t.Errorf("expected a missing ssh key to fail, got %v", err)
+	}
+}
+
+func TestSiteGitProvider(t *testing.T) {
+	s, err := newSite(log.New(io.Discard, "", 0), Options{RepoURL: "git@git.example.com:team/notes.git", ProviderName: "git"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.contents.fp.(*gitClient); !ok {
+		t.Errorf("expected a git client, got %T", s.contents.fp)
+	}
+}
@@ -0,0 +1,111 @@
+package thoughts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// gitlabClient reads a repo from GitLab, gitlab.com or self-hosted, through
+// its REST API.
+type gitlabClient struct {
+	archiveClient
+	apiURL string
+
+	// project is the repo's full path, such as group/subgroup/name.
+	project string
+}
+
+// newGitLabClient creates a client for the project at repoURL. token, when
+// set, is a personal or project access token for private repos.
+func newGitLabClient(logger *log.Logger, repoURL, token string) (*gitlabClient, error) {
+	base, project, err := parseGitLabURL(repoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	if token != "" {
+		header.Set("PRIVATE-TOKEN", token)
+	}
+
+	logger.Printf("gitlab project: %s\n", project)
+	return &gitlabClient{
+		archiveClient: newArchiveClient(logger, header),
+		apiURL:        base + "/api/v4",
+		project:       project,
+	}, nil
+}
+
+// parseGitLabURL splits a GitLab repo reference into the instance's base
+// URL and the project path. Bare group/name paths are on gitlab.com, and
+// links into the project such as /-/tree/main are trimmed.
+func parseGitLabURL(repoURL string) (base, project string, err error) {
+	raw := strings.TrimSpace(repoURL)
+	if raw == "" {
+		return "", "", errors.New("invalid repo url: empty")
+	}
+
+	if !strings.Contains(raw, "://") {
+		if first, _, _ := strings.Cut(strings.TrimPrefix(raw, "/"), "/"); !strings.Contains(first, ".") {
+			raw = "gitlab.com/" + raw
+		}
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid repo url %q: %w", repoURL, err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", "", fmt.Errorf("invalid repo url %q: unsupported scheme %q", repoURL, u.Scheme)
+	}
+
+	p, _, _ := strings.Cut(u.Path, "/-/")
+	p = strings.TrimSuffix(strings.Trim(p, "/"), ".git")
+	switch {
+	case p == "":
+		return "", "", fmt.Errorf("invalid repo url %q: missing group and name", repoURL)
+	case strings.Contains(p, "//"):
+		return "", "", fmt.Errorf("invalid repo url %q: empty path segment", repoURL)
+	case !strings.Contains(p, "/"):
+		return "", "", fmt.Errorf("invalid repo url %q: missing repo name after group %q", repoURL, p)
+	}
+
+	return u.Scheme + "://" + u.Host, p, nil
+}
+
+// projectURL is the API URL for the project, which takes its path
+// URL-encoded as a single segment.
+func (g *gitlabClient) projectURL() string {
+	return g.apiURL + "/projects/" + url.PathEscape(g.project)
+}
+
+func (g *gitlabClient) LastHash(ctx context.Context) (string, error) {
+	commitsURL := g.projectURL() + "/repository/commits?ref_name=main&per_page=1"
+	g.logger.Printf("getting last hash %s\n", commitsURL)
+
+	var commits []struct {
+		ID string `json:"id"`
+	}
+	if err := g.getJSON(ctx, commitsURL, &commits); err != nil {
+		return "", err
+	}
+	if len(commits) == 0 {
+		return "", errors.New("no commits found, must commit to the repo before using the agent")
+	}
+
+	g.logger.Printf("last hash is %s\n", commits[0].ID)
+	return commits[0].ID, nil
+}
+
+// Contents downloads the archive of main. Like a GitHub zipball it wraps the
+// repo in a single name-ref-hash directory, which extracting strips.
+func (g *gitlabClient) Contents(ctx context.Context) (fs.FS, func(), error) {
+	return g.fetchArchive(ctx, g.projectURL()+"/repository/archive.zip?sha=main")
+}
@@ -0,0 +1,155 @@
+package thoughts
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// newGitLabServer mocks the API of a GitLab instance hosting group/sub/notes,
+// which requires token.
+func newGitLabServer(t *testing.T, token string, zipfile string) *httptest.Server {
+	t.Helper()
+
+	project := "/api/v4/projects/group%2Fsub%2Fnotes"
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.EscapedPath() {
+		case project + "/repository/commits":
+			if got := r.URL.Query().Get("ref_name"); got != "main" {
+				t.Errorf("expected commits on main, got %q", got)
+			}
+			w.Write([]byte(`[{"id": "0123abcd", "short_id": "0123"}]`))
+		case project + "/repository/archive.zip":
+			if got := r.URL.Query().Get("sha"); got != "main" {
+				t.Errorf("expected the archive of main, got %q", got)
+			}
+			http.ServeFile(w, r, zipfile)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(svr.Close)
+	return svr
+}
+
+func TestGitLabClient(t *testing.T) {
+	// GitLab names the archive's directory name-ref-hash.
+	zipfile, cleanup := createTestZip(t, fstest.MapFS{
+		"notes-main-0123abcd/README.md":  {Data: []byte("# Notes")},
+		"notes-main-0123abcd/ideas/a.md": {Data: []byte("# Idea A")},
+	})
+	defer cleanup()
+
+	svr := newGitLabServer(t, "secret", zipfile)
+	glclient, err := newGitLabClient(log.New(io.Discard, "", 0), svr.URL+"/group/sub/notes", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	glclient.tempDir = t.TempDir()
+
+	hash, err := glclient.LastHash(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != "0123abcd" {
+		t.Errorf("expected hash 0123abcd, got %q", hash)
+	}
+
+	contents, cleanupContents, err := glclient.Contents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := fs.ReadFile(contents, "notes-main-0123abcd/ideas/a.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "# Idea A" {
+		t.Errorf("unexpected contents %q", b)
+	}
+	cleanupContents()
+	if entries, _ := os.ReadDir(glclient.tempDir); len(entries) != 0 {
+		t.Errorf("expected the archive to be removed, found %v", entries)
+	}
+
+	// The repo strips GitLab's top-level directory like GitHub's.
+	r := newRepo(log.New(io.Discard, "", 0), glclient, RenderOptions{})
+	if err := r.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.Document("ideas/a"); !ok {
+		t.Errorf("expected ideas/a to be served, got %v", r.Documents())
+	}
+
+	unauthorized, err := newGitLabClient(log.New(io.Discard, "", 0), svr.URL+"/group/sub/notes", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unauthorized.LastHash(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected a missing token to be refused, got %v", err)
+	}
+}
+
+func TestParseGitLabURL(t *testing.T) {
+	tests := []struct {
+		in      string
+		base    string
+		project string
+		wantErr string
+	}{
+		{in: "https://gitlab.com/group/notes", base: "https://gitlab.com", project: "group/notes"},
+		{in: "group/sub/notes", base: "https://gitlab.com", project: "group/sub/notes"},
+		{in: "gitlab.example.com/team/notes.git", base: "https://gitlab.example.com", project: "team/notes"},
+		{in: "https://gitlab.com/group/notes/-/tree/main", base: "https://gitlab.com", project: "group/notes"},
+		{in: "http://localhost:8080/group/notes/", base: "http://localhost:8080", project: "group/notes"},
+		{in: "", wantErr: "empty"},
+		{in: "https://gitlab.com/group", wantErr: "missing repo name"},
+		{in: "https://gitlab.com/", wantErr: "missing group and name"},
+		{in: "ssh://gitlab.com/group/notes", wantErr: "unsupported scheme"},
+	}
+
+	for _, tt := range tests {
+		base, project, err := parseGitLabURL(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%q: expected an error containing %q, got %v", tt.in, tt.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+			continue
+		}
+		if base != tt.base || project != tt.project {
+			t.Errorf("%q: expected %s %s, got %s %s", tt.in, tt.base, tt.project, base, project)
+		}
+	}
+}
+
+func TestSiteProviderFlag(t *testing.T) {
+	s, err := newSite(log.New(io.Discard, "", 0), Options{RepoURL: "group/notes", ProviderName: "gitlab"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.contents.fp.(*gitlabClient); !ok {
+		t.Errorf("expected a gitlab client, got %T", s.contents.fp)
+	}
+
+	if _, err := newSite(log.New(io.Discard, "", 0), Options{RepoURL: "group/notes", ProviderName: "bitbucket"}); err == nil || !strings.Contains(err.Error(), "unknown provider") {
+		t.Errorf("expected an unknown provider error, got %v", err)
+	}
+	if _, err := newSite(log.New(io.Discard, "", 0), Options{RepoURL: "group/notes", ProviderName: "gitlab", UseCache: true}); err == nil {
+		t.Error("expected the cache to be refused for gitlab")
+	}
+}
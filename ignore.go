@@ -0,0 +1,136 @@
+package thoughts
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"strings"
+)
+
+// ignoreFile is read from the repo root to keep paths off the site.
+const ignoreFile = ".thoughtsignore"
+
+// ignoreRule is one line of an ignore file, following gitignore
+// conventions: a trailing slash only matches directories, a leading "!"
+// re-includes paths, and patterns without a slash match at any depth.
+type ignoreRule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+type ignoreRules []ignoreRule
+
+func parseIgnore(data []byte) ignoreRules {
+	var rules ignoreRules
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// Ignored reports whether the repo-relative file path p is excluded. Later
+// rules take precedence over earlier ones.
+func (rules ignoreRules) Ignored(p string) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.matches(p) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// Matches reports whether any rule matches the repo-relative file path p,
+// ignoring negation. It is how -include rules are checked.
+func (rules ignoreRules) Matches(p string) bool {
+	for _, rule := range rules {
+		if rule.matches(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the rule matches the file at p or any of the
+// directories containing it.
+func (r ignoreRule) matches(p string) bool {
+	segments := strings.Split(p, "/")
+
+	last := len(segments)
+	if r.dirOnly {
+		last-- // the final segment is the file itself
+	}
+
+	for i := 1; i <= last; i++ {
+		candidate := segments[i-1]
+		if r.anchored {
+			candidate = strings.Join(segments[:i], "/")
+		}
+		if matchGlob(r.pattern, candidate) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchGlob is path.Match extended with "**", which matches any number of
+// path segments.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range segments {
+				if matchSegments(pattern, segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], segments[0]); err != nil || !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+
+	return len(segments) == 0
+}
@@ -0,0 +1,154 @@
+package thoughts
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	rules := parseIgnore([]byte(`
+# drafts stay private
+private/
+*.draft.md
+!keep.draft.md
+/top-only.md
+notes/**/scratch.md
+`))
+
+	tests := []struct {
+		path    string
+		ignored bool
+	}{
+		{"private/secret.md", true},
+		{"thoughts/private/secret.md", true},
+		{"private.md", false},
+		{"thoughts/idea.draft.md", true},
+		{"idea.draft.md", true},
+		{"keep.draft.md", false},
+		{"thoughts/keep.draft.md", false},
+		{"top-only.md", true},
+		{"thoughts/top-only.md", false},
+		{"notes/scratch.md", true},
+		{"notes/a/b/scratch.md", true},
+		{"other/scratch.md", false},
+		{"thoughts/2024-01-01.md", false},
+	}
+
+	for _, tt := range tests {
+		if got := rules.Ignored(tt.path); got != tt.ignored {
+			t.Errorf("Ignored(%q) = %v, want %v", tt.path, got, tt.ignored)
+		}
+	}
+}
+
+func TestRepoRespectsIgnoreFile(t *testing.T) {
+	r := newTestRepo(t, map[string]string{
+		".thoughtsignore":       "private/\n*.draft.md\n",
+		"README.md":             "# Index",
+		"public.md":             "# Public",
+		"private/secret.md":     "# Secret",
+		"thoughts/wip.draft.md": "# Draft",
+	})
+
+	if _, ok := r.Document("public"); !ok {
+		t.Error("expected public document")
+	}
+	for _, p := range []string{"private/secret", "thoughts/wip.draft"} {
+		if _, ok := r.Document(p); ok {
+			t.Errorf("expected %s to be ignored", p)
+		}
+	}
+
+	r = newTestRepo(t, map[string]string{
+		"README.md":         "# Index",
+		"private/secret.md": "# Secret",
+	})
+	if _, ok := r.Document("private/secret"); !ok {
+		t.Error("expected nothing to be ignored without an ignore file")
+	}
+}
+
+func TestRepoExcludeGlobs(t *testing.T) {
+	files := map[string]string{
+		".thoughtsignore":     "private/\n",
+		"README.md":           "# Index",
+		"public.md":           "# Public",
+		"private/secret.md":   "# Secret",
+		"drafts/idea.md":      "# Idea",
+		"drafts/deep/more.md": "# More",
+	}
+
+	r := newRepo(log.New(io.Discard, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{})
+	r.exclude = parseIgnore([]byte("drafts/*"))
+	if err := r.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := r.Document("public"); !ok {
+		t.Error("expected public document")
+	}
+	for _, p := range []string{"drafts/idea", "drafts/deep/more", "private/secret"} {
+		if _, ok := r.Document(p); ok {
+			t.Errorf("expected %s to be excluded", p)
+		}
+	}
+}
+
+func TestRepoIncludeGlobs(t *testing.T) {
+	files := map[string]string{
+		"README.md":          "# Index",
+		"about.md":           "# About",
+		"notes/a.md":         "# A",
+		"notes/deep/b.md":    "# B",
+		"notes/drafts/c.md":  "# C",
+		"journal/2024-01.md": "# Journal",
+	}
+
+	tests := []struct {
+		name    string
+		include string
+		exclude string
+		want    []string
+		unwant  []string
+	}{
+		{
+			name:    "include only",
+			include: "notes/**",
+			want:    []string{"notes/a", "notes/deep/b", "notes/drafts/c"},
+			unwant:  []string{"about", "journal/2024-01"},
+		},
+		{
+			name:    "exclude wins over include",
+			include: "notes/**",
+			exclude: "notes/drafts/",
+			want:    []string{"notes/a", "notes/deep/b"},
+			unwant:  []string{"notes/drafts/c", "about"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRepo(log.New(io.Discard, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{})
+			r.include = parseIgnore([]byte(tt.include))
+			r.exclude = parseIgnore([]byte(tt.exclude))
+			if err := r.Sync(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if r.Index() == nil {
+				t.Error("expected the root README to be kept as the index")
+			}
+			for _, p := range tt.want {
+				if _, ok := r.Document(p); !ok {
+					t.Errorf("expected %s to be included", p)
+				}
+			}
+			for _, p := range tt.unwant {
+				if _, ok := r.Document(p); ok {
+					t.Errorf("expected %s to be left out", p)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package thoughts
+
+import (
+	"encoding/base64"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// localImages maps the destinations of the repo-relative images in doc,
+// the document at docPath, to the paths of the files in the repo. Remote
+// images and data URIs are left out.
+func localImages(doc ast.Node, docPath string) map[string]string {
+	images := make(map[string]string)
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		img, ok := node.(*ast.Image)
+		if !ok || !entering {
+			return ast.GoToNext
+		}
+		dest := string(img.Destination)
+		if key, ok := linkKey(dest, docPath); ok && key != "README" {
+			images[dest] = key
+		}
+		return ast.GoToNext
+	})
+	return images
+}
+
+// loadImages reads the document's local images from repo and keeps them as
+// data URIs for rendering. Images that are missing or aren't images keep
+// their destination.
+func (d *document) loadImages(repo fs.FS, images map[string]string) {
+	for dest, p := range images {
+		b, err := fs.ReadFile(repo, p)
+		if err != nil {
+			continue
+		}
+
+		typ := mime.TypeByExtension(path.Ext(p))
+		if typ == "" {
+			typ = http.DetectContentType(b)
+		}
+		typ, _, _ = strings.Cut(typ, ";")
+		if !strings.HasPrefix(typ, "image/") {
+			continue
+		}
+
+		if d.images == nil {
+			d.images = make(map[string]string)
+		}
+		d.images[dest] = "data:" + typ + ";base64," + base64.StdEncoding.EncodeToString(b)
+	}
+}
+
+// transformImages points images at the data URIs loaded for them.
+func transformImages(doc ast.Node, images map[string]string) {
+	if len(images) == 0 {
+		return
+	}
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if img, ok := node.(*ast.Image); ok && entering {
+			if uri, ok := images[string(img.Destination)]; ok {
+				img.Destination = []byte(uri)
+			}
+		}
+		return ast.GoToNext
+	})
+}
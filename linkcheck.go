@@ -0,0 +1,96 @@
+package thoughts
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// brokenLink is an internal link whose target isn't a document.
+type brokenLink struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// siteRoutes are the pages the site serves itself rather than from a
+// document, keyed the way linkKey stores links.
+var siteRoutes = map[string]bool{
+	"archive":    true,
+	"status":     true,
+	"linkcheck":  true,
+	"version":    true,
+	"healthz":    true,
+	"tags":       true,
+	"api/index":  true,
+	"admin/sync": true,
+}
+
+// isSiteRoute reports whether a link key points at one of the site's own
+// pages, which are served before documents are looked up.
+func isSiteRoute(key string) bool {
+	if siteRoutes[key] {
+		return true
+	}
+	for _, prefix := range []string{"tags/", "raw/", "api/docs/"} {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// findBrokenLinks checks the links between documents once they are all
+// indexed. Only links to documents are checked: relative links without an
+// extension, since rewriteLinks strips markdown ones, and wiki links.
+// Links to other files in the repo and to the site's own pages are left
+// alone.
+func (r *repo) findBrokenLinks(docs []*document, wiki map[string]*document) []brokenLink {
+	var broken []brokenLink
+
+	for _, d := range docs {
+		if d != r.index && r.documents[d.servedPath()] != d {
+			continue
+		}
+
+		for _, key := range d.links {
+			if r.isIndexKey(key) || path.Ext(key) != "" || isSiteRoute(key) {
+				continue
+			}
+			if _, ok := r.documents[key]; !ok {
+				broken = append(broken, brokenLink{Source: d.path, Target: key})
+			}
+		}
+		for _, target := range d.wikiTargets {
+			if _, ok := wiki[wikiKey(target)]; !ok {
+				broken = append(broken, brokenLink{Source: d.path, Target: "[[" + target + "]]"})
+			}
+		}
+	}
+
+	sort.Slice(broken, func(i, j int) bool {
+		if broken[i].Source != broken[j].Source {
+			return broken[i].Source < broken[j].Source
+		}
+		return broken[i].Target < broken[j].Target
+	})
+	return broken
+}
+
+// BrokenLinks returns the broken internal links found by the last sync.
+func (r *repo) BrokenLinks() []brokenLink {
+	return r.brokenLinks
+}
+
+func (s *Site) serveLinkcheck(w http.ResponseWriter, r *http.Request) {
+	broken := s.activeRepo().BrokenLinks()
+	if broken == nil {
+		broken = []brokenLink{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(broken); err != nil {
+		s.logger.Printf("failed to write link check: %v\n", err)
+	}
+}
@@ -0,0 +1,85 @@
+package thoughts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+var brokenLinkFiles = map[string]string{
+	"README.md":   "# Home\n\n[a](./a.md), [gone](./gone.md), [img](./img.png) and [web](https://example.com/x).",
+	"a.md":        "# A\n\n[[Home]], [[Nowhere]], [up](./README.md) and [empty folder](./folder/).",
+	"folder/b.md": "# B",
+}
+
+func TestRepoBrokenLinks(t *testing.T) {
+	var buf bytes.Buffer
+	r := newRepo(log.New(&buf, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(brokenLinkFiles)}, RenderOptions{})
+	if err := r.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []brokenLink{
+		{Source: "README.md", Target: "gone"},
+		{Source: "a.md", Target: "[[Nowhere]]"},
+		{Source: "a.md", Target: "folder"},
+	}
+	got := r.BrokenLinks()
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %v, got %v", want[i], got[i])
+		}
+	}
+	if !strings.Contains(buf.String(), "broken link in README.md to gone") {
+		t.Errorf("expected broken links to be logged, got %s", buf.String())
+	}
+}
+
+func TestRepoFailOnBrokenLinks(t *testing.T) {
+	r := newRepo(log.New(io.Discard, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(brokenLinkFiles)}, RenderOptions{})
+	r.failOnBrokenLinks = true
+
+	err := r.Sync(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "found 3 broken links") {
+		t.Errorf("expected the sync to fail, got %v", err)
+	}
+}
+
+func TestRepoBrokenLinksSkipsSiteRoutes(t *testing.T) {
+	files := map[string]string{
+		"README.md": "# Home\n\n[go](/tags/go), [tags](/tags), [archive](/archive), [status](/status) and [llms](/llms.txt).",
+	}
+	r := newRepo(log.New(io.Discard, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{})
+	r.failOnBrokenLinks = true
+
+	if err := r.Sync(context.Background()); err != nil {
+		t.Fatalf("expected links to site routes to pass, got %v", err)
+	}
+	if got := r.BrokenLinks(); len(got) != 0 {
+		t.Errorf("expected no broken links, got %v", got)
+	}
+}
+
+func TestSiteServeLinkcheck(t *testing.T) {
+	s := newTestSite(t, brokenLinkFiles)
+
+	var report []brokenLink
+	if err := json.Unmarshal([]byte(get(t, s, "/linkcheck")), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 3 || report[0].Target != "gone" {
+		t.Errorf("expected the broken links, got %v", report)
+	}
+
+	s = newTestSite(t, map[string]string{"README.md": "# Home"})
+	if body := strings.TrimSpace(get(t, s, "/linkcheck")); body != "[]" {
+		t.Errorf("expected an empty report, got %s", body)
+	}
+}
@@ -0,0 +1,48 @@
+package thoughts
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// llmsTxt is the site's llms.txt: its title and description, then every
+// document as a markdown link with its excerpt, ordered by path. Links are
+// made absolute against origin.
+func (s *Site) llmsTxt(origin string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", s.siteTitle())
+	description := s.siteDescription()
+	if index := s.activeRepo().Index(); description == "" && index != nil {
+		description = index.Excerpt()
+	}
+	if description != "" {
+		fmt.Fprintf(&b, "\n> %s\n", description)
+	}
+
+	docs := make([]*document, 0, len(s.activeRepo().Documents()))
+	for _, d := range s.activeRepo().Documents() {
+		docs = append(docs, d)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].servedPath() < docs[j].servedPath()
+	})
+
+	if len(docs) > 0 {
+		b.WriteString("\n## Notes\n\n")
+	}
+	for _, d := range docs {
+		fmt.Fprintf(&b, "- [%s](%s)", d.Title(), origin+d.URL())
+		if excerpt := d.Excerpt(); excerpt != "" {
+			fmt.Fprintf(&b, ": %s", excerpt)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func (s *Site) serveLLMs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte(s.llmsTxt(s.feedOrigin(r))))
+}
@@ -0,0 +1,30 @@
+package thoughts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServeLLMs(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md":       "# Index\n\nNotes on building things.",
+		"about.md":        "# About\n\nWho writes this.",
+		"thoughts/foo.md": "# Foo",
+	})
+	s.baseURL = "https://example.com"
+
+	want := "# test\n\n> Notes on building things.\n\n## Notes\n\n" +
+		"- [About](https://example.com/about): Who writes this.\n" +
+		"- [Foo](https://example.com/thoughts/foo)\n"
+	if got := get(t, s, "/llms.txt"); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestServeLLMsUsesRequestHost(t *testing.T) {
+	s := newTestSite(t, map[string]string{"README.md": "# Index", "about.md": "# About"})
+
+	if got := get(t, s, "/llms.txt"); !strings.Contains(got, "- [About](http://example.com/about)\n") {
+		t.Errorf("expected links from the request host, got %s", got)
+	}
+}
@@ -0,0 +1,57 @@
+package thoughts
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// documentMeta describes a document for clients building their own index.
+type documentMeta struct {
+	Path    string   `json:"path"`
+	Title   string   `json:"title"`
+	Date    string   `json:"date,omitempty"`
+	Tags    []string `json:"tags"`
+	Excerpt string   `json:"excerpt"`
+}
+
+// Metadata describes every document apart from the index, newest first.
+// Undated documents follow the dated ones, ordered by path.
+func (r *repo) Metadata() []documentMeta {
+	docs := make([]*document, 0, len(r.documents))
+	for _, d := range r.documents {
+		docs = append(docs, d)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		a, b := docs[i], docs[j]
+		if !a.Date().Equal(b.Date()) {
+			return a.Date().After(b.Date())
+		}
+		return a.path < b.path
+	})
+
+	meta := make([]documentMeta, 0, len(docs))
+	for _, d := range docs {
+		m := documentMeta{
+			Path:    d.servedPath(),
+			Title:   d.Title(),
+			Tags:    d.Tags(),
+			Excerpt: d.Excerpt(),
+		}
+		if m.Tags == nil {
+			m.Tags = []string{}
+		}
+		if !d.Date().IsZero() {
+			m.Date = d.Date().Format("2006-01-02")
+		}
+		meta = append(meta, m)
+	}
+	return meta
+}
+
+func (s *Site) serveMetadata(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.activeRepo().Metadata()); err != nil {
+		s.logger.Printf("failed to write document metadata: %v\n", err)
+	}
+}
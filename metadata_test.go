@@ -0,0 +1,63 @@
+package thoughts
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestServeMetadata(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md":              "# Index",
+		"about.md":               "# About\n\nWho writes this.",
+		"thoughts/2024-03-15.md": "---\ntags: [go, web]\n---\n# March\n\nSpring notes.",
+		"thoughts/2023-12-31.md": "# December",
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/index", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected a JSON content type, got %q", ct)
+	}
+
+	var got []map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, m := range got {
+		paths = append(paths, m["path"].(string))
+	}
+	want := []string{"thoughts/2024-03-15", "thoughts/2023-12-31", "about"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("expected %v, got %v", want, paths)
+	}
+
+	march := got[0]
+	if march["title"] != "March" || march["date"] != "2024-03-15" || march["excerpt"] != "Spring notes." {
+		t.Errorf("unexpected metadata %v", march)
+	}
+	if !reflect.DeepEqual(march["tags"], []any{"go", "web"}) {
+		t.Errorf("expected tags go and web, got %v", march["tags"])
+	}
+	if _, ok := got[2]["date"]; ok {
+		t.Errorf("expected no date for an undated document, got %v", got[2]["date"])
+	}
+	if tags, ok := got[2]["tags"].([]any); !ok || len(tags) != 0 {
+		t.Errorf("expected an empty tag list, got %v", got[2]["tags"])
+	}
+}
+
+func TestServeMetadataEmpty(t *testing.T) {
+	s := newTestSite(t, map[string]string{"README.md": "# Index"})
+
+	if body := get(t, s, "/api/index"); strings.TrimSpace(body) != "[]" {
+		t.Errorf("expected an empty array, got %q", body)
+	}
+}
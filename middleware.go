@@ -0,0 +1,303 @@
+package thoughts
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// probePaths are served without authentication or rate limits so health
+// checks keep working on protected sites. They must not say anything about
+// the site; /version, which names the deployed hash, stays behind auth.
+var probePaths = map[string]bool{
+	"/healthz": true,
+}
+
+// apiPaths are the JSON endpoints, along with everything under /api/,
+// that -cors-origins opens to other origins.
+var apiPaths = map[string]bool{
+	"/version":   true,
+	"/linkcheck": true,
+}
+
+func isAPIPath(p string) bool {
+	return apiPaths[p] || strings.HasPrefix(p, "/api/")
+}
+
+// handler is the site wrapped in the middleware its options ask for.
+func (s *Site) handler() http.Handler {
+	var h http.Handler = s
+	if s.basicAuthUser != "" {
+		h = s.basicAuth(h)
+	}
+	if len(s.corsOrigins) > 0 {
+		h = s.cors(h) // outside auth, browsers send preflights without credentials
+	}
+	if s.rateLimiter != nil {
+		h = s.rateLimit(h)
+	}
+	h = s.securityHeaders(h)
+	if s.accessLog {
+		h = s.logRequests(h)
+	}
+	return h
+}
+
+// statusRecorder captures the status and size of a response for logging.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// logRequests writes an access log line for every request once it has been
+// served.
+func (s *Site) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		s.logger.Printf("method=%s path=%q status=%d size=%d duration=%s ip=%s\n",
+			r.Method, r.URL.Path, rec.status, rec.size, time.Since(start).Round(time.Microsecond), s.clientIP(r))
+	})
+}
+
+// parseTrustedProxies parses -trusted-proxies, which are CIDRs or single
+// addresses.
+func parseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		if addr, err := netip.ParseAddr(v); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q, use a CIDR such as 10.0.0.0/8 or an address", v)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func (s *Site) trusted(addr netip.Addr) bool {
+	for _, p := range s.trustedProxies {
+		if p.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address of the client behind r. Forwarding headers are
+// only believed when the request comes from a trusted proxy, and the
+// client is the last X-Forwarded-For hop that isn't one, so a client can't
+// spoof its address by sending the header itself.
+func (s *Site) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil || !s.trusted(remote) {
+		return host
+	}
+
+	if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+		hops := strings.Split(strings.Join(fwd, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			if !s.trusted(addr) || i == 0 {
+				return addr.String()
+			}
+		}
+	}
+
+	if real, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return real.String()
+	}
+	return host
+}
+
+// cspNonce stands in for the nonce in a Content-Security-Policy. Each
+// response gets a fresh one, which the template puts on its inline scripts.
+const cspNonce = "'nonce-{nonce}'"
+
+// defaultCSP allows the template's nonced inline scripts, its inline
+// styles, and KaTeX and mermaid from their CDN. Other inline scripts and
+// event handlers are blocked, so raw HTML in notes can't run any.
+// Sanitized notes can't add frames or media either, so the policy doesn't
+// allow them from elsewhere.
+const defaultCSP = "default-src 'self'; " +
+	"script-src 'self' " + cspNonce + " https://cdn.jsdelivr.net; " +
+	"style-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net; " +
+	"font-src 'self' https://cdn.jsdelivr.net; " +
+	"img-src * data:; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// rawHTMLCSP adds embeds to defaultCSP for notes with raw HTML, such as
+// videos in iframes.
+const rawHTMLCSP = defaultCSP + "; frame-src https:; media-src https:"
+
+// securityHeaders sets headers that harden every response. -csp replaces
+// the Content-Security-Policy, or drops it when "off".
+func (s *Site) securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		h.Set("X-Frame-Options", "DENY")
+		if s.csp != "" && s.csp != "off" {
+			csp := s.csp
+			if strings.Contains(csp, cspNonce) {
+				nonce := newNonce()
+				csp = strings.ReplaceAll(csp, cspNonce, "'nonce-"+nonce+"'")
+				r = r.WithContext(context.WithValue(r.Context(), nonceKey{}, nonce))
+			}
+			h.Set("Content-Security-Policy", csp)
+		}
+		if s.tlsCert != "" || s.autocert != nil {
+			h.Set("Strict-Transport-Security", "max-age=31536000")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// nonceKey is the request context key of the response's CSP nonce.
+type nonceKey struct{}
+
+// newNonce returns a random CSP nonce.
+func newNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// requestNonce is the CSP nonce securityHeaders chose for r, or "" when the
+// policy has none.
+func requestNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(nonceKey{}).(string)
+	return nonce
+}
+
+// routePath is the path of r relative to the site, or the mounted site,
+// serving it.
+func (s *Site) routePath(r *http.Request) string {
+	p := strings.TrimPrefix(r.URL.Path, s.basePath)
+	if m, rest := s.mountFor(p); m != nil {
+		p = rest
+	}
+	return p
+}
+
+// cors lets the allowed origins call the JSON API from a browser, answering
+// preflight requests itself. Other routes are left alone.
+func (s *Site) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !isAPIPath(s.routePath(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed := s.allowOrigin(origin)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			if allowed != "*" {
+				w.Header().Add("Vary", "Origin")
+			}
+		}
+
+		if !preflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if allowed == "" {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-None-Match")
+		w.Header().Set("Access-Control-Max-Age", "86400")
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or
+// "" when it isn't allowed.
+func (s *Site) allowOrigin(origin string) string {
+	for _, o := range s.corsOrigins {
+		if o == "*" {
+			return "*"
+		}
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// basicAuth requires the configured credentials on every request except
+// probes.
+func (s *Site) basicAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := s.routePath(r)
+		if probePaths[route] || (route == adminSyncPath && s.adminToken != "") {
+			// /admin/sync checks its own token.
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !s.basicAuthOK(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="thoughts", charset="UTF-8"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// basicAuthOK reports whether r carries the basic auth credentials.
+func (s *Site) basicAuthOK(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(s.basicAuthUser)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(s.basicAuthPass)) == 1
+	return ok && userOK && passOK
+}
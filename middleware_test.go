@@ -0,0 +1,257 @@
+package thoughts
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSiteBasicAuth(t *testing.T) {
+	s := newTestSite(t, map[string]string{"README.md": "# Hello"})
+	s.basicAuthUser, s.basicAuthPass = "admin", "secret"
+	h := s.handler()
+
+	tests := []struct {
+		name       string
+		path       string
+		user, pass string
+		code       int
+	}{
+		{"no credentials", "/", "", "", http.StatusUnauthorized},
+		{"wrong password", "/", "admin", "nope", http.StatusUnauthorized},
+		{"wrong user", "/", "root", "secret", http.StatusUnauthorized},
+		{"authorized", "/", "admin", "secret", http.StatusOK},
+		{"probe", "/healthz", "", "", http.StatusOK},
+		{"version", "/version", "", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.user != "" {
+			req.SetBasicAuth(tt.user, tt.pass)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.code, rec.Code)
+		}
+		if tt.code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected a WWW-Authenticate header", tt.name)
+		}
+	}
+}
+
+func TestSiteAccessLog(t *testing.T) {
+	s := newTestSite(t, map[string]string{"README.md": "# Hello"})
+	var buf bytes.Buffer
+	s.logger = log.New(&buf, "", 0)
+	s.accessLog = true
+	h := s.handler()
+
+	for _, path := range []string{"/", "/missing"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %q", buf.String())
+	}
+	for i, want := range []string{`method=GET path="/" status=200`, `method=GET path="/missing" status=404`} {
+		if !strings.HasPrefix(lines[i], want) || !strings.Contains(lines[i], "duration=") {
+			t.Errorf("expected line %d to start with %s, got %s", i, want, lines[i])
+		}
+	}
+}
+
+func TestSiteCORS(t *testing.T) {
+	s := newTestSite(t, map[string]string{"README.md": "# Hello"})
+	s.corsOrigins = []string{"https://app.example.com"}
+	s.basicAuthUser, s.basicAuthPass = "admin", "secret"
+	h := s.handler()
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		origin    string
+		preflight bool
+		code      int
+		allow     string
+	}{
+		{"preflight", http.MethodOptions, "/version", "https://app.example.com", true, http.StatusNoContent, "https://app.example.com"},
+		{"preflight skips auth", http.MethodOptions, "/linkcheck", "https://app.example.com", true, http.StatusNoContent, "https://app.example.com"},
+		{"disallowed preflight", http.MethodOptions, "/version", "https://evil.example.com", true, http.StatusForbidden, ""},
+		{"allowed origin", http.MethodGet, "/version", "https://app.example.com", false, http.StatusUnauthorized, "https://app.example.com"},
+		{"disallowed origin", http.MethodGet, "/version", "https://evil.example.com", false, http.StatusUnauthorized, ""},
+		{"html route", http.MethodGet, "/", "https://app.example.com", false, http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Origin", tt.origin)
+		if tt.preflight {
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.code, rec.Code)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+			t.Errorf("%s: expected allow origin %q, got %q", tt.name, tt.allow, got)
+		}
+		if tt.code == http.StatusNoContent && rec.Header().Get("Access-Control-Allow-Methods") == "" {
+			t.Errorf("%s: expected allowed methods on a preflight", tt.name)
+		}
+	}
+
+	s.corsOrigins = []string{"*"}
+	req := httptest.NewRequest(http.MethodGet, "/version", nil)
+	req.Header.Set("Origin", "https://anywhere.example.com")
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("expected any origin to be allowed with *, got %q", got)
+	}
+}
+
+func TestSiteClientIP(t *testing.T) {
+	s := newTestSite(t, map[string]string{"README.md": "# Hello"})
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.trustedProxies = proxies
+
+	tests := []struct {
+		name    string
+		remote  string
+		forward string
+		realIP  string
+		want    string
+	}{
+		{"direct", "203.0.113.7:1234", "", "", "203.0.113.7"},
+		{"untrusted spoofing", "203.0.113.7:1234", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.5:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"trusted chain", "10.0.0.5:1234", "198.51.100.9, 198.51.100.1, 192.168.1.1", "", "198.51.100.1"},
+		{"trusted real ip", "192.168.1.1:1234", "", "198.51.100.3", "198.51.100.3"},
+		{"trusted without headers", "10.0.0.5:1234", "", "", "10.0.0.5"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remote
+		if tt.forward != "" {
+			req.Header.Set("X-Forwarded-For", tt.forward)
+		}
+		if tt.realIP != "" {
+			req.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := s.clientIP(req); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+
+	var buf bytes.Buffer
+	s.logger = log.New(&buf, "", 0)
+	s.accessLog = true
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.5:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	s.handler().ServeHTTP(httptest.NewRecorder(), req)
+	if !strings.Contains(buf.String(), "ip=198.51.100.1") {
+		t.Errorf("expected the client ip in the access log, got %s", buf.String())
+	}
+
+	if _, err := parseTrustedProxies([]string{"not-a-cidr"}); err == nil {
+		t.Error("expected an error for an invalid trusted proxy")
+	}
+}
+
+func TestSiteSecurityHeaders(t *testing.T) {
+	s := newTestSite(t, map[string]string{"README.md": "# Hello"})
+	s.csp = defaultCSP
+
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	for header, want := range map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"Referrer-Policy":        "strict-origin-when-cross-origin",
+		"X-Frame-Options":        "DENY",
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("expected %s: %s, got %q", header, want, got)
+		}
+	}
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("expected no HSTS without tls, got %q", got)
+	}
+	if got := rec.Header().Get("Content-Security-Policy"); !strings.HasPrefix(got, "default-src 'self'; script-src 'self' 'nonce-") {
+		t.Errorf("expected the default csp with a nonce, got %q", got)
+	}
+
+	s.tlsCert = "cert.pem"
+	s.csp = "off"
+	rec = httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("Strict-Transport-Security"); got == "" {
+		t.Error("expected HSTS with tls")
+	}
+	if got := rec.Header().Get("Content-Security-Policy"); got != "" {
+		t.Errorf("expected no csp when turned off, got %q", got)
+	}
+}
+
+func TestSiteCSPNonce(t *testing.T) {
+	s := newTestSiteWithOptions(t, map[string]string{"README.md": "# Hello\n\n$$x$$\n"}, RenderOptions{AllowRawHTML: true, Math: true})
+	s.csp = rawHTMLCSP
+	s.themeToggle = true
+
+	nonceRE := regexp.MustCompile(`script-src [^;]*'nonce-([^']+)'`)
+	serve := func(r *http.Request) (*httptest.ResponseRecorder, string) {
+		rec := httptest.NewRecorder()
+		s.handler().ServeHTTP(rec, r)
+		csp := rec.Header().Get("Content-Security-Policy")
+		if scripts := regexp.MustCompile(`script-src [^;]*`).FindString(csp); strings.Contains(scripts, "'unsafe-inline'") {
+			t.Errorf("expected no unsafe-inline scripts, got %q", scripts)
+		}
+		m := nonceRE.FindStringSubmatch(csp)
+		if m == nil {
+			return rec, ""
+		}
+		return rec, m[1]
+	}
+
+	rec, nonce := serve(httptest.NewRequest(http.MethodGet, "/", nil))
+	if nonce == "" {
+		t.Fatalf("expected a nonce in the csp, got %q", rec.Header().Get("Content-Security-Policy"))
+	}
+	body := rec.Body.String()
+	inline := strings.Count(body, "<script") - strings.Count(body, "<script defer src=")
+	if n := strings.Count(body, `nonce="`+nonce+`"`); inline == 0 || n != inline {
+		t.Errorf("expected every inline script to carry the nonce, %d of %d do:\n%s", n, inline, body)
+	}
+	for _, handler := range []string{"onload=", "onclick="} {
+		if strings.Contains(body, handler) {
+			t.Errorf("expected no inline %s handlers, got %s", handler, body)
+		}
+	}
+
+	if _, again := serve(httptest.NewRequest(http.MethodGet, "/", nil)); again == nonce {
+		t.Error("expected a fresh nonce for every response")
+	}
+
+	// A 304 keeps the policy the cached page was served with.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified || rec.Header().Get("Content-Security-Policy") != "" {
+		t.Errorf("expected a 304 without a csp, got %d %q", rec.Code, rec.Header().Get("Content-Security-Policy"))
+	}
+}
@@ -0,0 +1,22 @@
+package thoughts
+
+import (
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/html"
+)
+
+// newMinifier collapses whitespace and drops comments in rendered pages
+// and their styles. Whitespace in <pre> and <code> is kept, and so are end
+// tags and quotes so custom templates minify predictably. Scripts are left
+// alone.
+func newMinifier() *minify.M {
+	m := minify.New()
+	m.AddFunc("text/css", css.Minify)
+	m.Add("text/html", &html.Minifier{
+		KeepDocumentTags: true,
+		KeepEndTags:      true,
+		KeepQuotes:       true,
+	})
+	return m
+}
@@ -0,0 +1,32 @@
+package thoughts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSiteMinify(t *testing.T) {
+	files := map[string]string{
+		"README.md": "# Home",
+		"code.md":   "# Code\n\n<!-- draft note -->\n\nSome   text.\n\n```go\nfunc main() {\n\tif true {\n\t\treturn\n\t}\n}\n```\n",
+	}
+
+	s := newTestSiteWithOptions(t, files, RenderOptions{AllowRawHTML: true})
+	plain := get(t, s, "/code")
+
+	s.minifier = newMinifier()
+	minified := get(t, s, "/code")
+
+	if len(minified) >= len(plain) {
+		t.Errorf("expected minified output to be smaller, got %d >= %d bytes", len(minified), len(plain))
+	}
+	if !strings.Contains(minified, "func main() {\n\tif true {\n\t\treturn\n\t}\n}") {
+		t.Errorf("expected code block whitespace to survive, got %s", minified)
+	}
+	if strings.Contains(minified, "draft note") {
+		t.Errorf("expected comments to be removed, got %s", minified)
+	}
+	if strings.Contains(minified, "\n\t\t\t") {
+		t.Errorf("expected template indentation to be collapsed, got %s", minified)
+	}
+}
@@ -0,0 +1,109 @@
+package thoughts
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Mount is a repo served under its own path prefix, for serving several
+// repos from one process.
+type Mount struct {
+	Path    string
+	RepoURL string
+}
+
+// ParseMount parses a -repo value of the form [path=]url.
+func ParseMount(v string) Mount {
+	if p, u, ok := strings.Cut(v, "="); ok && strings.HasPrefix(p, "/") {
+		return Mount{Path: cleanBasePath(p), RepoURL: u}
+	}
+	return Mount{RepoURL: v}
+}
+
+// newMounts creates a site for every mount, each with its own repos and
+// sync loop, served below the parent's base path.
+func newMounts(logger *log.Logger, opts Options) ([]*Site, error) {
+	seen := make(map[string]bool)
+	sites := make([]*Site, 0, len(opts.Mounts))
+
+	for _, m := range opts.Mounts {
+		if m.Path == "" {
+			return nil, fmt.Errorf("repo %s needs a mount path, e.g. /notes=%s, when serving several repos", m.RepoURL, m.RepoURL)
+		}
+		if seen[m.Path] {
+			return nil, fmt.Errorf("mount path %s is used by more than one repo", m.Path)
+		}
+		seen[m.Path] = true
+
+		mopts := opts
+		mopts.RepoURL = m.RepoURL
+		mopts.BasePath = cleanBasePath(opts.BasePath) + m.Path
+		mopts.Mounts = nil
+
+		s, err := newSite(logger, mopts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create site for %s: %w", m.Path, err)
+		}
+		sites = append(sites, s)
+	}
+
+	return sites, nil
+}
+
+// mountPath is where a mounted site lives relative to its parent.
+func (s *Site) mountPath(child *Site) string {
+	return strings.TrimPrefix(child.basePath, s.basePath)
+}
+
+// mountFor finds the mounted site serving urlPath, which is relative to the
+// parent's base path, and returns the path relative to that site.
+func (s *Site) mountFor(urlPath string) (*Site, string) {
+	for _, m := range s.mounts {
+		p := s.mountPath(m)
+		if rest, ok := strings.CutPrefix(urlPath, p); ok && (rest == "" || strings.HasPrefix(rest, "/")) {
+			return m, rest
+		}
+	}
+	return nil, ""
+}
+
+// mountLinks links to each mounted site for the root listing.
+func (s *Site) mountLinks() []pageLink {
+	links := make([]pageLink, 0, len(s.mounts))
+	for _, m := range s.mounts {
+		p := s.mountPath(m)
+		links = append(links, pageLink{Name: strings.TrimPrefix(p, "/"), URL: m.basePath + "/"})
+	}
+	return links
+}
+
+// serveMounts lists the mounted repos at the root and hands everything else
+// to the site it is mounted under.
+func (s *Site) serveMounts(w http.ResponseWriter, r *http.Request, urlPath string) {
+	switch urlPath {
+	case "/":
+		s.servePage(w, r, s.newPage(nil), "mounts", s.mountLinks())
+		return
+	case "/version":
+		s.serveVersion(w, r)
+		return
+	case "/healthz":
+		s.serveHealthz(w, r)
+		return
+	case "/robots.txt":
+		s.serveRobots(w, r)
+		return
+	case "/favicon.ico":
+		s.serveFavicon(w, r)
+		return
+	}
+
+	if m, _ := s.mountFor(urlPath); m != nil {
+		m.ServeHTTP(w, r)
+		return
+	}
+
+	http.Error(w, "not found", http.StatusNotFound)
+}
@@ -0,0 +1,71 @@
+package thoughts
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestMount(t *testing.T, path string, files map[string]string) *Site {
+	t.Helper()
+
+	s := newTestSiteWithOptions(t, files, RenderOptions{basePath: path})
+	s.basePath = path
+	return s
+}
+
+func TestSiteMounts(t *testing.T) {
+	s := &Site{
+		title:  "test",
+		logger: log.New(io.Discard, "", 0),
+		tpl:    mustParseWrapper(t),
+		mounts: []*Site{
+			newTestMount(t, "/work", map[string]string{"README.md": "# Work", "thoughts/foo.md": "# Foo"}),
+			newTestMount(t, "/personal", map[string]string{"README.md": "# Personal", "bar.md": "# Bar"}),
+		},
+	}
+
+	index := get(t, s, "/")
+	for _, want := range []string{`<a href="/work/">work</a>`, `<a href="/personal/">personal</a>`} {
+		if !strings.Contains(index, want) {
+			t.Errorf("expected %s in the index, got %s", want, index)
+		}
+	}
+
+	if body := get(t, s, "/work/thoughts/foo"); !strings.Contains(body, `<h1 id="foo">Foo`) || !strings.Contains(body, `href="/work/thoughts/foo"`) {
+		t.Errorf("expected foo from the work repo, got %s", body)
+	}
+	if body := get(t, s, "/personal/bar"); !strings.Contains(body, `<h1 id="bar">Bar`) {
+		t.Errorf("expected bar from the personal repo, got %s", body)
+	}
+
+	for _, p := range []string{"/personal/thoughts/foo", "/work/bar", "/other/", "/workthoughts/foo"} {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, p, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", p, rec.Code)
+		}
+	}
+}
+
+func TestNewMountsErrors(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+
+	tests := []struct {
+		mounts []Mount
+		err    string
+	}{
+		{[]Mount{ParseMount("/a=josebalius/a"), ParseMount("josebalius/b")}, "needs a mount path"},
+		{[]Mount{ParseMount("/a=josebalius/a"), ParseMount("/a/=josebalius/b")}, "used by more than one repo"},
+	}
+
+	for _, tt := range tests {
+		_, err := newMounts(logger, Options{Mounts: tt.mounts})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%v: expected error containing %q, got %v", tt.mounts, tt.err, err)
+		}
+	}
+}
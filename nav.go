@@ -0,0 +1,84 @@
+package thoughts
+
+import (
+	"sort"
+	"strings"
+)
+
+// navItem is an entry in the navigation sidebar. Folders have children and
+// only have a URL when they have a landing page.
+type navItem struct {
+	Name     string
+	URL      string
+	Current  bool
+	Children []*navItem
+
+	weight *int
+
+	// order is the earliest order file position of the entry or anything
+	// under it, zero when none are listed.
+	order int
+}
+
+// buildNav groups documents into a tree by directory, marking the entry for
+// current, which may be nil.
+func buildNav(documents map[string]*document, current *document) []*navItem {
+	root := &navItem{}
+
+	for p, doc := range documents {
+		item := root
+		for _, segment := range strings.Split(p, "/") {
+			item = item.child(segment)
+			if doc.order > 0 && (item.order == 0 || doc.order < item.order) {
+				item.order = doc.order
+			}
+		}
+
+		item.URL = doc.URL()
+		item.Current = doc == current
+		item.weight = doc.meta.Weight
+	}
+
+	root.sort()
+	return root.Children
+}
+
+func (n *navItem) child(name string) *navItem {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+
+	c := &navItem{Name: name}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// sort puts children listed in the order file first, in its order, then
+// orders the rest by weight, then alphabetically, with unweighted entries
+// after weighted ones.
+func (n *navItem) sort() {
+	sort.Slice(n.Children, func(i, j int) bool {
+		a, b := n.Children[i], n.Children[j]
+		switch {
+		case a.order > 0 && b.order > 0 && a.order != b.order:
+			return a.order < b.order
+		case a.order > 0 && b.order == 0:
+			return true
+		case a.order == 0 && b.order > 0:
+			return false
+		case a.weight != nil && b.weight != nil && *a.weight != *b.weight:
+			return *a.weight < *b.weight
+		case a.weight != nil && b.weight == nil:
+			return true
+		case a.weight == nil && b.weight != nil:
+			return false
+		}
+		return a.Name < b.Name
+	})
+
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
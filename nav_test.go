@@ -0,0 +1,69 @@
+package thoughts
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBuildNav(t *testing.T) {
+	weight := func(w int) *int { return &w }
+	documents := map[string]*document{
+		"zeta":            {path: "zeta.md"},
+		"alpha":           {path: "alpha.md"},
+		"first":           {path: "first.md", meta: frontmatter{Weight: weight(1)}},
+		"thoughts":        {path: "thoughts/README.md"},
+		"thoughts/b":      {path: "thoughts/b.md"},
+		"thoughts/a":      {path: "thoughts/a.md"},
+		"projects/go/one": {path: "projects/go/one.md"},
+	}
+	current := documents["thoughts/b"]
+
+	nav := buildNav(documents, current)
+
+	var names []string
+	for _, item := range nav {
+		names = append(names, item.Name)
+	}
+	if got, want := strings.Join(names, ","), "first,alpha,projects,thoughts,zeta"; got != want {
+		t.Fatalf("expected top-level order %s, got %s", want, got)
+	}
+
+	thoughts := nav[3]
+	if thoughts.URL != "/thoughts/" {
+		t.Errorf("expected folder with landing page to link to /thoughts/, got %q", thoughts.URL)
+	}
+	if len(thoughts.Children) != 2 || thoughts.Children[0].Name != "a" || !thoughts.Children[1].Current {
+		t.Errorf("expected thoughts/a then current thoughts/b, got %+v", thoughts.Children)
+	}
+
+	projects := nav[2]
+	if projects.URL != "" {
+		t.Errorf("expected folder without landing page to have no URL, got %q", projects.URL)
+	}
+	if projects.Children[0].Children[0].URL != "/projects/go/one" {
+		t.Errorf("expected nested document URL, got %q", projects.Children[0].Children[0].URL)
+	}
+}
+
+func TestSiteRendersNav(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md":       "# Hello",
+		"thoughts/foo.md": "# Foo",
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/thoughts/foo", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), `<li class="current"><a href="/thoughts/foo">foo</a>`) {
+		t.Fatalf("expected current nav entry, got %s", rec.Body.String())
+	}
+
+	s.noNav = true
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if strings.Contains(rec.Body.String(), `<nav`) {
+		t.Fatalf("expected no nav with noNav, got %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,101 @@
+package thoughts
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// orderFiles are read from the repo root, first one found wins, to put
+// documents in reading order instead of alphabetical order.
+var orderFiles = []string{"order.txt", ".thoughts-order"}
+
+// parseOrder reads the document paths listed in an order file, one per
+// line. Blank lines and # comments are skipped, and paths may keep their
+// markdown extension or leading slash.
+func parseOrder(data []byte) []string {
+	var paths []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p, _ := trimMarkdownExt(strings.Trim(line, "/"))
+		paths = append(paths, p)
+	}
+
+	return paths
+}
+
+func readOrder(repo fs.FS) ([]string, error) {
+	for _, name := range orderFiles {
+		matches, err := fs.Glob(repo, name)
+		if err != nil || len(matches) == 0 {
+			continue
+		}
+
+		b, err := fs.ReadFile(repo, matches[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		return parseOrder(b), nil
+	}
+	return nil, nil
+}
+
+// applyOrder numbers the documents listed in order by their position,
+// leaving unlisted ones at zero. Listed paths that aren't documents are
+// logged and skipped.
+func (r *repo) applyOrder(docs []*document, order []string) {
+	byPath := make(map[string]*document, len(docs))
+	for _, d := range docs {
+		p, _ := trimMarkdownExt(d.path)
+		byPath[p] = d
+		byPath[d.servedPath()] = d
+	}
+
+	n := 0
+	for _, p := range order {
+		d, ok := byPath[p]
+		if !ok {
+			r.logger.Printf("warning: order file lists %s, which isn't a document\n", p)
+			continue
+		}
+		if d.order == 0 {
+			n++
+			d.order = n
+		}
+	}
+}
+
+// buildOrdered lists the documents in reading order: the ones in the order
+// file first, then the rest by path. It is nil without an order file.
+func buildOrdered(documents map[string]*document) []*document {
+	var ordered []*document
+	listed := false
+	for _, d := range documents {
+		ordered = append(ordered, d)
+		listed = listed || d.order > 0
+	}
+	if !listed {
+		return nil
+	}
+
+	sort.Slice(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		switch {
+		case a.order > 0 && b.order > 0:
+			return a.order < b.order
+		case a.order > 0 || b.order > 0:
+			return a.order > 0
+		}
+		return a.servedPath() < b.servedPath()
+	})
+	return ordered
+}
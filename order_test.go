@@ -0,0 +1,83 @@
+package thoughts
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOrder(t *testing.T) {
+	got := parseOrder([]byte("# reading order\nintro.md\n\n/guide/setup\nguide/README.md\n"))
+	want := []string{"intro", "guide/setup", "guide/README"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseOrder = %v, want %v", got, want)
+	}
+}
+
+func TestRepoOrderFile(t *testing.T) {
+	var logs bytes.Buffer
+	files := map[string]string{
+		"order.txt":       "intro.md\nguide/setup.md\nmissing.md\nguide/usage.md\n",
+		"README.md":       "# Handbook",
+		"appendix.md":     "# Appendix",
+		"zebra.md":        "# Zebra",
+		"intro.md":        "# Intro",
+		"guide/usage.md":  "# Usage",
+		"guide/setup.md":  "# Setup",
+		"guide/extras.md": "# Extras",
+	}
+	r := newRepo(log.New(&logs, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{})
+	if err := r.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, item := range buildNav(r.Documents(), nil) {
+		names = append(names, item.Name)
+		if item.Name == "guide" {
+			var children []string
+			for _, c := range item.Children {
+				children = append(children, c.Name)
+			}
+			if got, want := strings.Join(children, ","), "setup,usage,extras"; got != want {
+				t.Errorf("expected guide order %s, got %s", want, got)
+			}
+		}
+	}
+	if got, want := strings.Join(names, ","), "intro,guide,appendix,zebra"; got != want {
+		t.Errorf("expected nav order %s, got %s", want, got)
+	}
+
+	tests := []struct {
+		path, prev, next string
+	}{
+		{"intro", "", "guide/setup"},
+		{"guide/setup", "intro", "guide/usage"},
+		{"guide/usage", "guide/setup", "appendix"},
+		{"appendix", "guide/usage", "guide/extras"},
+		{"zebra", "guide/extras", ""},
+	}
+	for _, tt := range tests {
+		prev, next := r.Neighbors(tt.path)
+		if got := servedPathOf(prev); got != tt.prev {
+			t.Errorf("%s: expected prev %q, got %q", tt.path, tt.prev, got)
+		}
+		if got := servedPathOf(next); got != tt.next {
+			t.Errorf("%s: expected next %q, got %q", tt.path, tt.next, got)
+		}
+	}
+
+	if !strings.Contains(logs.String(), "order file lists missing") {
+		t.Errorf("expected a warning about the missing entry, got %q", logs.String())
+	}
+}
+
+func servedPathOf(d *document) string {
+	if d == nil {
+		return ""
+	}
+	return d.servedPath()
+}
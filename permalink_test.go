@@ -0,0 +1,82 @@
+package thoughts
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRepoPermalinks(t *testing.T) {
+	var logs bytes.Buffer
+	files := map[string]string{
+		"README.md":              "---\npermalink: /home\n---\n# Index",
+		"thoughts/2024-03-15.md": "---\npermalink: /spring/\n---\n# Spring",
+		"spring.md":              "# Shadowed",
+		"a.md":                   "---\npermalink: shared\n---\n# A\n\nFrom a.",
+		"b.md":                   "---\npermalink: shared\n---\n# B\n\nFrom b.",
+		"guide/README.md":        "---\npermalink: handbook\n---\n# Guide",
+	}
+	logger := log.New(&logs, "", 0)
+	r := newRepo(logger, &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{})
+	if err := r.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s := &Site{title: "test", logger: logger, versionA: r, versionB: r, tpl: mustParseWrapper(t)}
+	s.active.Store(r)
+
+	if r.Index() == nil || r.Index().Title() != "Index" {
+		t.Fatal("expected the root README to stay the index")
+	}
+	if !strings.Contains(logs.String(), "a.md and b.md both have the permalink shared, keeping a.md") {
+		t.Errorf("expected a warning for the colliding permalinks, got %q", logs.String())
+	}
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+		body     string
+	}{
+		{"/spring", http.StatusOK, "", "Spring"},
+		{"/shared", http.StatusOK, "", "From a."},
+		{"/handbook", http.StatusOK, "", "Guide"},
+		{"/thoughts/2024-03-15", http.StatusMovedPermanently, "/spring", ""},
+		{"/guide/", http.StatusMovedPermanently, "/handbook", ""},
+		{"/home", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, rec.Code)
+			}
+			if got := rec.Header().Get("Location"); got != tt.location {
+				t.Errorf("expected location %q, got %q", tt.location, got)
+			}
+			if !strings.Contains(rec.Body.String(), tt.body) {
+				t.Errorf("expected %q in %s", tt.body, rec.Body)
+			}
+		})
+	}
+}
+
+func TestDocumentInvalidPermalink(t *testing.T) {
+	for _, permalink := range []string{"/", "../outside"} {
+		d, err := newDocument("notes/a.md", "abc123", []byte("---\npermalink: "+permalink+"\n---\n# A"), RenderOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.servedPath(); got != "notes/a" {
+			t.Errorf("expected permalink %q to fall back to notes/a, got %q", permalink, got)
+		}
+		if len(d.problems) != 1 || !strings.Contains(d.problems[0].Error(), "invalid permalink") {
+			t.Errorf("expected permalink %q to be reported, got %v", permalink, d.problems)
+		}
+	}
+}
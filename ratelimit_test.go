@@ -0,0 +1,70 @@
+package thoughts
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefills(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newRateLimiter(2, 2)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("expected request %d within the burst to be allowed", i)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok {
+		t.Fatal("expected the request past the burst to be limited")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("expected to wait 500ms for a token, got %s", wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("expected other clients to have their own bucket")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("expected a token after refilling")
+	}
+}
+
+func TestSiteRateLimit(t *testing.T) {
+	s := newTestSite(t, map[string]string{"README.md": "# Hello"})
+	s.rateLimiter = newRateLimiter(1, 3)
+	h := s.handler()
+
+	serve := func(path, remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	limited := 0
+	for i := 0; i < 6; i++ {
+		rec := serve("/", "203.0.113.7:1234")
+		if rec.Code == http.StatusTooManyRequests {
+			limited++
+			if rec.Header().Get("Retry-After") != "1" {
+				t.Errorf("expected Retry-After: 1, got %q", rec.Header().Get("Retry-After"))
+			}
+		}
+	}
+	if limited != 3 {
+		t.Errorf("expected 3 of 6 requests to be limited, got %d", limited)
+	}
+
+	if rec := serve("/healthz", "203.0.113.7:1234"); rec.Code != http.StatusOK {
+		t.Errorf("expected probes not to be limited, got %d", rec.Code)
+	}
+	if rec := serve("/", "203.0.113.8:1234"); rec.Code != http.StatusOK {
+		t.Errorf("expected another client to be allowed, got %d", rec.Code)
+	}
+}
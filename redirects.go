@@ -0,0 +1,95 @@
+package thoughts
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// redirectFiles are read from the repo root, first one found wins, to send
+// the old paths of moved documents to their new ones.
+var redirectFiles = []string{"redirects", ".thoughts-redirects"}
+
+// redirect sends requests for one path to another.
+type redirect struct {
+	from, to string
+}
+
+// parseRedirects reads "old new" pairs, one per line. Blank lines and #
+// comments are skipped, and paths may keep their markdown extension or
+// leading slash. Lines without exactly two paths are skipped.
+func parseRedirects(data []byte) []redirect {
+	var redirects []redirect
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		from, _ := trimMarkdownExt(strings.Trim(fields[0], "/"))
+		to, _ := trimMarkdownExt(strings.Trim(fields[1], "/"))
+		redirects = append(redirects, redirect{from: from, to: to})
+	}
+
+	return redirects
+}
+
+func readRedirects(repo fs.FS) ([]redirect, error) {
+	for _, name := range redirectFiles {
+		matches, err := fs.Glob(repo, name)
+		if err != nil || len(matches) == 0 {
+			continue
+		}
+
+		b, err := fs.ReadFile(repo, matches[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		return parseRedirects(b), nil
+	}
+	return nil, nil
+}
+
+// resolveRedirects points each redirect at the document it targets.
+// Redirects to paths that aren't documents are logged and skipped.
+// Documents with a permalink also redirect from where their filename would
+// put them, unless another document is served there.
+func (r *repo) resolveRedirects(redirects []redirect) map[string]*document {
+	resolved := make(map[string]*document, len(redirects))
+	for _, d := range r.documents {
+		if d.permalink == "" {
+			continue
+		}
+		if p := d.derivedPath(); r.documents[p] == nil {
+			resolved[p] = d
+		}
+	}
+	for _, rd := range redirects {
+		var d *document
+		if rd.to == "" || rd.to == "README" {
+			d = r.index
+		} else {
+			d = r.documents[rd.to]
+		}
+		if d == nil {
+			r.logger.Printf("warning: redirects file sends %s to %s, which isn't a document\n", rd.from, rd.to)
+			continue
+		}
+		resolved[rd.from] = d
+	}
+	return resolved
+}
+
+// Redirect returns the document that replaced the one once served at path.
+func (r *repo) Redirect(path string) (*document, bool) {
+	d, ok := r.redirects[path]
+	return d, ok
+}
@@ -0,0 +1,66 @@
+package thoughts
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRedirects(t *testing.T) {
+	got := parseRedirects([]byte("# moved notes\n/old.md /new\n\nthoughts/a thoughts/b.md\nbroken line here\n"))
+	want := []redirect{{from: "old", to: "new"}, {from: "thoughts/a", to: "thoughts/b"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRedirects = %v, want %v", got, want)
+	}
+}
+
+func TestSiteServeRedirects(t *testing.T) {
+	var logs bytes.Buffer
+	files := map[string]string{
+		"redirects":       "old-name new-name\nnotes/gone missing\nhome /\nguide/intro.md guide/\n",
+		"README.md":       "# Index",
+		"new-name.md":     "# New",
+		"guide/README.md": "# Guide",
+	}
+	logger := log.New(&logs, "", 0)
+	r := newRepo(logger, &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{})
+	if err := r.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s := &Site{title: "test", logger: logger, versionA: r, versionB: r, tpl: mustParseWrapper(t)}
+	s.active.Store(r)
+
+	if !strings.Contains(logs.String(), "sends notes/gone to missing, which isn't a document") {
+		t.Errorf("expected a warning for the dangling redirect, got %q", logs.String())
+	}
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/old-name", http.StatusMovedPermanently, "/new-name"},
+		{"/home", http.StatusMovedPermanently, "/"},
+		{"/guide/intro", http.StatusMovedPermanently, "/guide/"},
+		{"/notes/gone", http.StatusNotFound, ""},
+		{"/new-name", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, rec.Code)
+			}
+			if got := rec.Header().Get("Location"); got != tt.location {
+				t.Errorf("expected location %q, got %q", tt.location, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package thoughts
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/html"
+)
+
+// renderHook replaces the HTML renderer's output for the nodes that the
+// enabled options care about.
+func (o RenderOptions) renderHook() html.RenderNodeFunc {
+	return func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+		switch n := node.(type) {
+		case *ast.CodeBlock:
+			if o.Mermaid && string(n.Info) == "mermaid" {
+				renderMermaid(w, n)
+				return ast.GoToNext, true
+			}
+			if o.CopyCode || o.CodeLineNumbers {
+				o.renderCodeBlock(w, n)
+				return ast.GoToNext, true
+			}
+		case *ast.Heading:
+			if !o.NoHeadingAnchors && !entering && n.HeadingID != "" {
+				renderHeadingClose(w, n)
+				return ast.GoToNext, true
+			}
+		}
+		return ast.GoToNext, false
+	}
+}
+
+// renderMermaid emits the diagram source for Mermaid to draw on page load.
+func renderMermaid(w io.Writer, block *ast.CodeBlock) {
+	io.WriteString(w, `<div class="mermaid">`)
+	html.EscapeHTML(w, block.Literal)
+	io.WriteString(w, "</div>\n")
+}
+
+// renderCodeBlock renders a code block the way the HTML renderer does,
+// inside a container with a button that copies the code when copyCode is
+// set, and with each line in its own span when codeLineNumbers is. The
+// numbers come from CSS, so they are neither selected nor copied.
+func (o RenderOptions) renderCodeBlock(w io.Writer, block *ast.CodeBlock) {
+	if o.CopyCode {
+		io.WriteString(w, `<div class="code-block">`)
+		io.WriteString(w, `<button type="button" class="copy-code" aria-label="copy code">copy</button>`)
+	}
+
+	io.WriteString(w, "<pre")
+	if o.CodeLineNumbers {
+		io.WriteString(w, ` class="line-numbers"`)
+	}
+	io.WriteString(w, "><code")
+	if lang, _, _ := strings.Cut(string(block.Info), " "); lang != "" {
+		io.WriteString(w, ` class="language-`)
+		html.EscapeHTML(w, []byte(lang))
+		io.WriteString(w, `"`)
+	}
+	io.WriteString(w, ">")
+
+	if o.CodeLineNumbers {
+		code, trailing := bytes.CutSuffix(block.Literal, []byte("\n"))
+		for i, line := range bytes.Split(code, []byte("\n")) {
+			if i > 0 {
+				io.WriteString(w, "\n")
+			}
+			io.WriteString(w, `<span class="line">`)
+			html.EscapeHTML(w, line)
+			io.WriteString(w, "</span>")
+		}
+		if trailing {
+			io.WriteString(w, "\n")
+		}
+	} else {
+		html.EscapeHTML(w, block.Literal)
+	}
+
+	io.WriteString(w, "</code></pre>")
+	if o.CopyCode {
+		io.WriteString(w, "</div>")
+	}
+	io.WriteString(w, "\n")
+}
+
+// renderHeadingClose ends a heading with a link to itself, shown on hover.
+func renderHeadingClose(w io.Writer, heading *ast.Heading) {
+	io.WriteString(w, ` <a class="anchor" href="#`)
+	html.EscapeHTML(w, []byte(heading.HeadingID))
+	io.WriteString(w, `" aria-label="link to this section">#</a>`)
+	fmt.Fprintf(w, "</h%d>\n", heading.Level)
+}
+
+// hasCodeBlock reports whether doc contains a fenced code block with the
+// given info string, or any code block when info is empty.
+func hasCodeBlock(doc ast.Node, info string) bool {
+	found := false
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if block, ok := node.(*ast.CodeBlock); ok && (info == "" || string(block.Info) == info) {
+			found = true
+			return ast.Terminate
+		}
+		return ast.GoToNext
+	})
+	return found
+}
+
+// transformTaskLists turns list items starting with "[ ]" or "[x]" into
+// disabled checkboxes, as GitHub renders them.
+func transformTaskLists(doc ast.Node) {
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		item, ok := node.(*ast.ListItem)
+		if !entering || !ok {
+			return ast.GoToNext
+		}
+
+		para, ok := ast.GetFirstChild(item).(*ast.Paragraph)
+		if !ok {
+			return ast.GoToNext
+		}
+		text, ok := ast.GetFirstChild(para).(*ast.Text)
+		if !ok || len(text.Literal) < 4 || text.Literal[0] != '[' || text.Literal[2] != ']' || text.Literal[3] != ' ' {
+			return ast.GoToNext
+		}
+
+		var checkbox string
+		switch text.Literal[1] {
+		case ' ':
+			checkbox = `<input type="checkbox" disabled> `
+		case 'x', 'X':
+			checkbox = `<input type="checkbox" disabled checked> `
+		default:
+			return ast.GoToNext
+		}
+
+		text.Literal = bytes.TrimLeft(text.Literal[4:], " ")
+		span := &ast.HTMLSpan{Leaf: ast.Leaf{Literal: []byte(checkbox), Parent: para}}
+		para.SetChildren(append([]ast.Node{span}, para.GetChildren()...))
+
+		return ast.GoToNext
+	})
+}
+
+// hasMath reports whether doc contains inline or block math.
+func hasMath(doc ast.Node) bool {
+	found := false
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		switch node.(type) {
+		case *ast.Math, *ast.MathBlock:
+			found = true
+			return ast.Terminate
+		}
+		return ast.GoToNext
+	})
+	return found
+}
+
+// calloutTypes are the GitHub alert types, with their titles.
+var calloutTypes = map[string]string{
+	"note":      "Note",
+	"tip":       "Tip",
+	"important": "Important",
+	"warning":   "Warning",
+	"caution":   "Caution",
+}
+
+// transformCallouts turns blockquotes starting with a line like "[!NOTE]"
+// into callout boxes, as GitHub renders alerts. Blockquotes with unknown
+// types are left as they are.
+func transformCallouts(doc ast.Node) {
+	var quotes []*ast.BlockQuote
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if quote, ok := node.(*ast.BlockQuote); ok && entering {
+			quotes = append(quotes, quote)
+		}
+		return ast.GoToNext
+	})
+
+	for _, quote := range quotes {
+		para, ok := ast.GetFirstChild(quote).(*ast.Paragraph)
+		if !ok {
+			continue
+		}
+		text, ok := ast.GetFirstChild(para).(*ast.Text)
+		if !ok {
+			continue
+		}
+
+		marker, rest, _ := bytes.Cut(text.Literal, []byte("\n"))
+		if !bytes.HasPrefix(marker, []byte("[!")) || !bytes.HasSuffix(marker, []byte("]")) {
+			continue
+		}
+		kind := strings.ToLower(string(marker[2 : len(marker)-1]))
+		title, ok := calloutTypes[kind]
+		if !ok {
+			continue
+		}
+
+		text.Literal = rest
+		children := quote.GetChildren()
+		if len(rest) == 0 && len(para.GetChildren()) == 1 {
+			children = children[1:]
+		}
+
+		open := fmt.Sprintf(`<div class="callout callout-%s"><p class="callout-title">%s</p>`, kind, title)
+		nodes := append([]ast.Node{&ast.HTMLBlock{Leaf: ast.Leaf{Literal: []byte(open)}}}, children...)
+		nodes = append(nodes, &ast.HTMLBlock{Leaf: ast.Leaf{Literal: []byte("</div>")}})
+
+		parent := quote.Parent
+		var siblings []ast.Node
+		for _, n := range parent.GetChildren() {
+			if n == quote {
+				siblings = append(siblings, nodes...)
+				continue
+			}
+			siblings = append(siblings, n)
+		}
+		for _, n := range nodes {
+			n.SetParent(parent)
+		}
+		parent.SetChildren(siblings)
+	}
+}
+
+// htmlCommentRE matches an HTML comment, which may span lines.
+var htmlCommentRE = regexp.MustCompile(`(?s)<!--.*?-->`)
+
+// transformComments removes HTML comments from the raw HTML in doc. Code
+// spans and blocks are separate nodes, so comments in them are kept.
+func transformComments(doc ast.Node) {
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		switch n := node.(type) {
+		case *ast.HTMLBlock:
+			n.Literal = htmlCommentRE.ReplaceAll(n.Literal, nil)
+		case *ast.HTMLSpan:
+			n.Literal = htmlCommentRE.ReplaceAll(n.Literal, nil)
+		}
+		return ast.GoToNext
+	})
+}
+
+// stripSourceComments removes HTML comments from markdown source, for
+// serving it raw. Comments in fenced code blocks and code spans are kept,
+// as they are when rendering.
+func stripSourceComments(src []byte) []byte {
+	var out, prose []byte
+	var fence []byte
+	for _, line := range bytes.SplitAfter(src, []byte("\n")) {
+		trimmed := bytes.TrimLeft(line, " ")
+		switch {
+		case fence != nil:
+			out = append(out, line...)
+			if bytes.HasPrefix(trimmed, fence) && len(bytes.TrimSpace(bytes.TrimLeft(trimmed, string(fence[:1])))) == 0 {
+				fence = nil
+			}
+		case bytes.HasPrefix(trimmed, []byte("```")) || bytes.HasPrefix(trimmed, []byte("~~~")):
+			out = append(out, stripProseComments(prose)...)
+			prose = nil
+			fence = trimmed[:len(trimmed)-len(bytes.TrimLeft(trimmed, string(trimmed[:1])))]
+			out = append(out, line...)
+		default:
+			prose = append(prose, line...)
+		}
+	}
+	return append(out, stripProseComments(prose)...)
+}
+
+// stripProseComments removes HTML comments from markdown outside code
+// blocks, skipping over code spans.
+func stripProseComments(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); {
+		switch {
+		case b[i] == '`':
+			n := 1
+			for i+n < len(b) && b[i+n] == '`' {
+				n++
+			}
+			end := codeSpanEnd(b, i+n, n)
+			if end < 0 {
+				end = i + n
+			}
+			out = append(out, b[i:end]...)
+			i = end
+		case bytes.HasPrefix(b[i:], []byte("<!--")):
+			end := bytes.Index(b[i+4:], []byte("-->"))
+			if end < 0 {
+				return append(out, b[i:]...)
+			}
+			i += 4 + end + 3
+		default:
+			out = append(out, b[i])
+			i++
+		}
+	}
+	return out
+}
+
+// codeSpanEnd finds the end of a code span opened by n backticks before
+// start: just past the next run of exactly n backticks, or -1.
+func codeSpanEnd(b []byte, start, n int) int {
+	for i := start; i < len(b); {
+		if b[i] != '`' {
+			i++
+			continue
+		}
+		run := 1
+		for i+run < len(b) && b[i+run] == '`' {
+			run++
+		}
+		if run == n {
+			return i + run
+		}
+		i += run
+	}
+	return -1
+}
+
+// transformLinkTargets opens external http(s) links in a new tab, leaving
+// links within the site in the same one. With allLinks set every link but
+// in-page fragments opens in a new tab.
+func transformLinkTargets(doc ast.Node, allLinks bool) {
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		link, ok := node.(*ast.Link)
+		if !entering || !ok || link.NoteID != 0 {
+			return ast.GoToNext
+		}
+
+		dest := string(link.Destination)
+		external := strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://")
+		if external || (allLinks && !strings.HasPrefix(dest, "#")) {
+			link.AdditionalAttributes = append(link.AdditionalAttributes, `target="_blank"`, `rel="noopener"`)
+		}
+		return ast.GoToNext
+	})
+}
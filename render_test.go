@@ -0,0 +1,376 @@
+package thoughts
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRenderTaskLists(t *testing.T) {
+	markdown := "- [ ] todo *soon*\n- [x] done\n  - [X] nested done\n  - [ ] nested todo\n- [y] not a task\n- plain\n\n| a |\n|---|\n| b |\n"
+
+	for _, opts := range []RenderOptions{{}, {AllowRawHTML: true}} {
+		out := renderString(t, markdown, opts)
+
+		if n := strings.Count(out, `<input type="checkbox"`); n != 4 {
+			t.Errorf("expected 4 checkboxes, got %d in %s", n, out)
+		}
+		if n := strings.Count(out, "checked"); n != 2 {
+			t.Errorf("expected 2 checked boxes, got %d in %s", n, out)
+		}
+		for _, want := range []string{"todo <em>soon</em>", "[y] not a task", "<li>plain</li>", "<table>", "<td>b</td>"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("expected %q in %s", want, out)
+			}
+		}
+		if strings.Contains(out, "[ ]") || strings.Contains(out, "[x]") {
+			t.Errorf("expected task markers to be replaced, got %s", out)
+		}
+	}
+}
+
+func TestRenderMermaid(t *testing.T) {
+	markdown := "# Diagram\n\n```mermaid\ngraph TD\n  A --> B\n```\n\n```go\nfmt.Println(\"a < b\")\n```\n"
+
+	out := renderString(t, markdown, RenderOptions{Mermaid: true})
+	if !strings.Contains(out, "<div class=\"mermaid\">graph TD\n  A --&gt; B\n</div>") {
+		t.Errorf("expected mermaid div, got %s", out)
+	}
+	if !strings.Contains(out, `<code class="language-go">`) {
+		t.Errorf("expected other code blocks to be untouched, got %s", out)
+	}
+
+	out = renderString(t, markdown, RenderOptions{})
+	if strings.Contains(out, `class="mermaid"`) {
+		t.Errorf("expected no mermaid div without the option, got %s", out)
+	}
+}
+
+func TestSiteLoadsMermaidOnlyWhenUsed(t *testing.T) {
+	s := newTestSiteWithOptions(t, map[string]string{
+		"README.md":  "# Hello",
+		"diagram.md": "```mermaid\ngraph TD\n  A --> B\n```\n",
+	}, RenderOptions{Mermaid: true})
+
+	body := get(t, s, "/diagram")
+	if !strings.Contains(body, "mermaid.initialize") {
+		t.Errorf("expected mermaid script on a page with a diagram, got %s", body)
+	}
+	if body := get(t, s, "/"); strings.Contains(body, "mermaid.initialize") {
+		t.Errorf("expected no mermaid script on a page without diagrams, got %s", body)
+	}
+}
+
+func TestRenderMath(t *testing.T) {
+	markdown := "Euler: $e^{i\\pi} + 1 = 0$\n\n$$\n\\int_0^1 x\\,dx\n$$\n\nCosts \\$5 and `$x$` stays code.\n"
+
+	out := renderString(t, markdown, RenderOptions{Math: true})
+	for _, want := range []string{
+		`<span class="math inline">\(e^{i\pi} + 1 = 0\)</span>`,
+		`<span class="math display">\[`,
+		"Costs $5",
+		"<code>$x$</code>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in %s", want, out)
+		}
+	}
+
+	out = renderString(t, markdown, RenderOptions{})
+	if strings.Contains(out, `class="math`) {
+		t.Errorf("expected no math spans without the option, got %s", out)
+	}
+}
+
+func TestSiteLoadsMathOnlyWhenUsed(t *testing.T) {
+	s := newTestSiteWithOptions(t, map[string]string{
+		"README.md": "Costs \\$5.",
+		"euler.md":  "$e^{i\\pi} + 1 = 0$",
+	}, RenderOptions{Math: true})
+
+	body := get(t, s, "/euler")
+	if !strings.Contains(body, "renderMathInElement") {
+		t.Errorf("expected katex script on a page with math, got %s", body)
+	}
+	if body := get(t, s, "/"); strings.Contains(body, "katex") {
+		t.Errorf("expected no katex script on a page without math, got %s", body)
+	}
+}
+
+func TestRenderHeadingAnchors(t *testing.T) {
+	markdown := "# Getting Started\n\n## Install *it*\n"
+
+	out := renderString(t, markdown, RenderOptions{})
+	for _, want := range []string{
+		`<h1 id="getting-started">Getting Started <a class="anchor" href="#getting-started"`,
+		`<h2 id="install-it">Install <em>it</em> <a class="anchor" href="#install-it"`,
+		"#</a></h2>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in %s", want, out)
+		}
+	}
+
+	out = renderString(t, markdown, RenderOptions{NoHeadingAnchors: true})
+	if strings.Contains(out, `class="anchor"`) {
+		t.Errorf("expected no anchors with the option, got %s", out)
+	}
+}
+
+func TestRenderCallouts(t *testing.T) {
+	for kind, title := range calloutTypes {
+		markdown := "> [!" + strings.ToUpper(kind) + "]\n> Read *this*.\n"
+
+		for _, opts := range []RenderOptions{{}, {AllowRawHTML: true}} {
+			out := renderString(t, markdown, opts)
+			for _, want := range []string{
+				`<div class="callout callout-` + kind + `"><p class="callout-title">` + title + `</p>`,
+				"<p>Read <em>this</em>.</p>",
+				"</div>",
+			} {
+				if !strings.Contains(out, want) {
+					t.Errorf("%s: expected %q in %s", kind, want, out)
+				}
+			}
+			if strings.Contains(out, "<blockquote>") || strings.Contains(out, "[!") {
+				t.Errorf("%s: expected the blockquote and marker to be replaced, got %s", kind, out)
+			}
+		}
+	}
+
+	for _, markdown := range []string{"> [!NOPE]\n> x\n", "> [!NOTE] inline\n", "> plain\n"} {
+		out := renderString(t, markdown, RenderOptions{})
+		if !strings.Contains(out, "<blockquote>") || strings.Contains(out, "callout") {
+			t.Errorf("expected a plain blockquote for %q, got %s", markdown, out)
+		}
+	}
+}
+
+func TestRenderLinkTargets(t *testing.T) {
+	markdown := "[rel](./b.md) [abs](/x) [frag](#y) [ext](https://example.com) [mail](mailto:a@example.com)"
+
+	out := renderString(t, markdown, RenderOptions{AllowRawHTML: true})
+	for _, want := range []string{
+		`<a href="./b">rel</a>`,
+		`<a href="/x">abs</a>`,
+		`<a href="#y">frag</a>`,
+		`<a target="_blank" rel="noopener" href="https://example.com">ext</a>`,
+		`<a href="mailto:a@example.com">mail</a>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %s in %s", want, out)
+		}
+	}
+
+	out = renderString(t, markdown, RenderOptions{AllowRawHTML: true, LinksNewTab: true})
+	if n := strings.Count(out, `target="_blank"`); n != 4 {
+		t.Errorf("expected every link but the fragment in a new tab, got %d in %s", n, out)
+	}
+	if !strings.Contains(out, `<a href="#y">frag</a>`) {
+		t.Errorf("expected fragments to stay in the page, got %s", out)
+	}
+}
+
+func TestRenderSmartTypography(t *testing.T) {
+	markdown := "\"Quoted\" and 'single' -- em---dash...\n\n`\"code\" -- ...`\n\n```\n\"block\" --- ...\n```"
+
+	out := renderString(t, markdown, RenderOptions{})
+	for _, want := range []string{
+		"“Quoted”",
+		"‘single’",
+		"– em—dash…",
+		"<code>&#34;code&#34; -- ...</code>",
+		"&#34;block&#34; --- ...",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %s in %s", want, out)
+		}
+	}
+
+	out = renderString(t, markdown, RenderOptions{NoSmartTypography: true})
+	for _, unwanted := range []string{"“", "‘", "–", "—", "…"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("expected no %s with smart typography off, got %s", unwanted, out)
+		}
+	}
+}
+
+func TestRenderFootnotes(t *testing.T) {
+	markdown := "First claim.[^1] Second claim.[^note]\n\n[^1]: The first source.\n[^note]: The second source."
+
+	d, err := newDocument("essays/on-notes.md", "abc123", []byte(markdown), RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := d.Render(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(b)
+
+	for _, want := range []string{
+		`<sup class="footnote-ref" id="fnref:essays-on-notes-1"><a href="#fn:essays-on-notes-1" rel="nofollow">1</a></sup>`,
+		`<sup class="footnote-ref" id="fnref:essays-on-notes-note"><a href="#fn:essays-on-notes-note" rel="nofollow">2</a></sup>`,
+		`<div class="footnotes">`,
+		`<li id="fn:essays-on-notes-1">`,
+		`The first source. <a class="footnote-return" href="#fnref:essays-on-notes-1" rel="nofollow">↩</a>`,
+		`<li id="fn:essays-on-notes-note">`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %s in %s", want, out)
+		}
+	}
+
+	other, err := newDocument("about.md", "abc123", []byte(markdown), RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = other.Render(nil)
+	if !strings.Contains(string(b), `id="fn:about-1"`) {
+		t.Errorf("expected footnote ids prefixed with the document, got %s", b)
+	}
+}
+
+func TestRenderCopyCode(t *testing.T) {
+	markdown := "```sh\n$ echo \"<hi>\"\n```\n\n    indented\n"
+
+	out := renderString(t, markdown, RenderOptions{CopyCode: true})
+	for _, want := range []string{
+		`<div class="code-block"><button type="button" class="copy-code" aria-label="copy code">copy</button><pre><code class="language-sh">$ echo &#34;&lt;hi&gt;&#34;` + "\n</code></pre></div>",
+		`<button type="button" class="copy-code" aria-label="copy code">copy</button><pre><code>indented`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %s in %s", want, out)
+		}
+	}
+
+	if out := renderString(t, markdown, RenderOptions{}); strings.Contains(out, "copy-code") {
+		t.Errorf("expected no copy buttons by default, got %s", out)
+	}
+}
+
+func TestSiteCopyCodeScript(t *testing.T) {
+	s := newTestSiteWithOptions(t, map[string]string{
+		"README.md":  "# Hello",
+		"code.md":    "# Code\n\n```\nls\n```",
+		"no-code.md": "# Prose",
+	}, RenderOptions{CopyCode: true})
+
+	if body := get(t, s, "/code"); !strings.Contains(body, "navigator.clipboard.writeText(code.textContent)") {
+		t.Errorf("expected the copy script on a page with code, got %s", body)
+	}
+	if body := get(t, s, "/no-code"); strings.Contains(body, "navigator.clipboard") {
+		t.Errorf("expected no copy script without code blocks, got %s", body)
+	}
+}
+
+func TestRenderCodeLineNumbers(t *testing.T) {
+	markdown := "```go\nfunc main() {\n\tfmt.Println(\"<3\")\n}\n```"
+
+	want := `<pre class="line-numbers"><code class="language-go">` +
+		`<span class="line">func main() {</span>` + "\n" +
+		`<span class="line">` + "\t" + `fmt.Println(&#34;&lt;3&#34;)</span>` + "\n" +
+		`<span class="line">}</span>` + "\n</code></pre>"
+
+	out := renderString(t, markdown, RenderOptions{CodeLineNumbers: true})
+	if !strings.Contains(out, want) {
+		t.Errorf("expected %s in %s", want, out)
+	}
+	if strings.Contains(out, "copy-code") {
+		t.Errorf("expected no copy button without -copy-code, got %s", out)
+	}
+
+	out = renderString(t, markdown, RenderOptions{CodeLineNumbers: true, CopyCode: true})
+	if !strings.Contains(out, `<div class="code-block"><button type="button" class="copy-code" aria-label="copy code">copy</button>`+want+"</div>") {
+		t.Errorf("expected numbered lines inside the copy container, got %s", out)
+	}
+}
+
+func TestRenderDefinitionLists(t *testing.T) {
+	markdown := "Glossary:\n\nTerm\n: The definition.\n\nOther term\n: First meaning.\n: Second meaning.\n\n- a plain\n- list\n\n1. and an\n2. ordered one\n"
+
+	out := renderString(t, markdown, RenderOptions{})
+	for _, want := range []string{
+		"<dl>", "<dt>Term</dt>", "<dd>The definition.</dd>",
+		"<dt>Other term</dt>", "<dd>First meaning.</dd>", "<dd>Second meaning.</dd>",
+		"<ul>\n<li>a plain</li>", "<ol>\n<li>and an</li>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in %s", want, out)
+		}
+	}
+	if n := strings.Count(out, "<dl>"); n != 1 {
+		t.Errorf("expected one definition list, got %d in %s", n, out)
+	}
+}
+
+func TestRenderStableHeadingIDs(t *testing.T) {
+	ids := func(markdown string) []string {
+		out := renderString(t, markdown, RenderOptions{})
+		var got []string
+		for _, m := range regexp.MustCompile(`<h\d id="([^"]+)"`).FindAllStringSubmatch(out, -1) {
+			got = append(got, m[1])
+		}
+		return got
+	}
+
+	got := ids("# Notes\n\n## Setup\n\n## Usage\n\n## Setup\n\n## Setup\n\n## Café & Crème!\n")
+	want := []string{"notes", "setup", "usage", "setup-2", "setup-3", "café-crème"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected ids %v, got %v", want, got)
+	}
+
+	// Headings elsewhere changing doesn't move the ids.
+	got = ids("# Notes\n\n## Intro\n\n## Setup\n\n## Setup\n")
+	if want := []string{"notes", "intro", "setup", "setup-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected ids %v, got %v", want, got)
+	}
+
+	// Explicit ids are kept and never reused.
+	got = ids("## Setup\n\n## Other {#setup}\n\n## Setup\n")
+	if want := []string{"setup-2", "setup", "setup-3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected ids %v, got %v", want, got)
+	}
+}
+
+func TestRenderStripsComments(t *testing.T) {
+	markdown := "Before <!-- inline secret --> after.\n\n<!--\nblock secret\n-->\n\n<div>kept <!-- nested secret --></div>\n\n```html\n<!-- code comment -->\n```\n\nSee `<!-- span comment -->`.\n"
+
+	out := renderString(t, markdown, RenderOptions{AllowRawHTML: true})
+	for _, secret := range []string{"inline secret", "block secret", "nested secret"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("expected %q to be stripped, got %s", secret, out)
+		}
+	}
+	for _, want := range []string{"Before  after.", "<div>kept </div>", "&lt;!-- code comment --&gt;", "<code>&lt;!-- span comment --&gt;</code>"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in %s", want, out)
+		}
+	}
+
+	out = renderString(t, markdown, RenderOptions{AllowRawHTML: true, KeepComments: true})
+	for _, want := range []string{"<!-- inline secret -->", "block secret"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q to be kept, got %s", want, out)
+		}
+	}
+
+	// The raw markdown is stripped the same way.
+	raw := get(t, newTestSite(t, map[string]string{"README.md": "# Index", "a.md": markdown}), "/raw/a")
+	for _, secret := range []string{"inline secret", "block secret", "nested secret"} {
+		if strings.Contains(raw, secret) {
+			t.Errorf("expected %q to be stripped from /raw/, got %s", secret, raw)
+		}
+	}
+	for _, want := range []string{"Before  after.", "<div>kept </div>", "```html\n<!-- code comment -->\n```", "`<!-- span comment -->`"} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("expected %q in /raw/, got %s", want, raw)
+		}
+	}
+
+	raw = get(t, newTestSiteWithOptions(t, map[string]string{"README.md": "# Index", "a.md": markdown}, RenderOptions{KeepComments: true}), "/raw/a")
+	if raw != markdown {
+		t.Errorf("expected /raw/ to be left alone with comments kept, got %s", raw)
+	}
+}
@@ -1,27 +1,104 @@
-package main
+package thoughts
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/fs"
-	"path/filepath"
+	"log"
+	"runtime"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 )
 
-type fileProvider interface {
+// FileProvider is where a repo's files come from. LastHash names the
+// repo's latest version, and Contents returns the files of the version it
+// last named, with a func to release them. A provider that is also an
+// io.Closer is closed once Serve returns.
+type FileProvider interface {
 	LastHash(ctx context.Context) (string, error)
 	Contents(ctx context.Context) (fs.FS, func(), error)
 }
 
 type repo struct {
-	fp        fileProvider
+	logger    *log.Logger
+	fp        FileProvider
+	flight    *singleflight.Group
+	syncMu    sync.Mutex
+	opts      RenderOptions
 	hash      string
+	syncedAt  time.Time
 	index     *document
 	documents map[string]*document
+
+	// folded maps lowercased served paths to documents, for requests
+	// that get the casing wrong.
+	folded map[string]*document
+
+	// dated holds the documents that have a date, oldest first.
+	dated   []*document
+	archive []archiveYear
+
+	// ordered holds every document in the order file's reading order,
+	// when the repo has one.
+	ordered []*document
+
+	// redirects maps old served paths to the documents that replaced
+	// them, from the redirects file.
+	redirects map[string]*document
+
+	// config is the site metadata from _config.yml or the index's
+	// frontmatter.
+	config siteConfig
+
+	// tags maps a tag's slug to the tag and its documents.
+	tags map[string]*tag
+
+	// wiki maps the names wiki links can use to the documents they
+	// resolve to. It lives here rather than on the documents, which are
+	// shared with the other buffer.
+	wiki map[string]*document
+
+	// backlinks maps a served path to the documents linking to it.
+	backlinks map[string][]*document
+
+	// brokenLinks are the internal links without a target. With
+	// failOnBrokenLinks set, any of them fails the sync.
+	brokenLinks       []brokenLink
+	failOnBrokenLinks bool
+
+	// strict fails a sync on problems with the content that are otherwise
+	// logged: no documents, no index document and broken links.
+	strict bool
+
+	// renderConcurrency bounds how many documents are rendered at once
+	// when warming renders after a sync. Zero means GOMAXPROCS.
+	renderConcurrency int
+
+	// exclude are rules from -exclude, checked after the repo's
+	// .thoughtsignore.
+	exclude ignoreRules
+
+	// include, when set, limits the documents to the paths matching one
+	// of its rules. The root README is always kept, as the site's index.
+	include ignoreRules
 }
 
-func newRepo(fp fileProvider) *repo {
-	return &repo{fp: fp, documents: make(map[string]*document)}
+func newRepo(logger *log.Logger, fp FileProvider, opts RenderOptions) *repo {
+	return &repo{
+		logger:    logger,
+		fp:        fp,
+		flight:    new(singleflight.Group),
+		opts:      opts,
+		documents: make(map[string]*document),
+	}
 }
 
 func (r *repo) Sync(ctx context.Context) error {
@@ -30,61 +107,455 @@ func (r *repo) Sync(ctx context.Context) error {
 		return fmt.Errorf("failed to get last hash: %w", err)
 	}
 
-	if hash == r.hash {
+	r.syncMu.Lock()
+	current := r.hash
+	if hash == current {
+		r.syncedAt = time.Now()
+	}
+	r.syncMu.Unlock()
+	if hash == current {
 		return nil
 	}
 
+	// Concurrent syncs for the same hash, from this repo or another sharing
+	// the group, share a single download.
+	v, err, _ := r.flight.Do(hash, func() (any, error) {
+		return r.fetchDocuments(ctx, hash)
+	})
+	if err != nil {
+		return err
+	}
+	snap := v.(*snapshot)
+	docs := snap.docs
+
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
+
+	// An empty repo or one without an index isn't necessarily broken, it
+	// may just have nothing to show yet, so unless strict keep serving a
+	// placeholder index and pick up content on a later sync.
+	placeholder := ""
+	switch {
+	case len(docs) == 0:
+		placeholder = emptyIndex
+		if err := r.problem(fmt.Errorf("repo has no markdown documents at %s", hash)); err != nil {
+			return err
+		}
+	case !slices.ContainsFunc(docs, (*document).isIndex):
+		placeholder = missingIndex
+		if err := r.problem(r.missingIndexError()); err != nil {
+			return err
+		}
+	}
+	if placeholder != "" {
+		r.logger.Printf("serving a placeholder index at %s\n", hash)
+		name := r.opts.IndexFile
+		if name == "" {
+			name = "README.md"
+		}
+		index, err := newDocument(name, hash, []byte(placeholder), r.opts)
+		if err != nil {
+			return err
+		}
+		// docs is shared with other repos syncing the same hash, so
+		// append to a copy.
+		docs = append(docs[:len(docs):len(docs)], index)
+	}
+	if err := r.indexDocuments(docs); err != nil {
+		return err
+	}
+	r.redirects = r.resolveRedirects(snap.redirects)
+	r.config = snap.config.or(siteConfig{Title: r.index.meta.Title, Description: r.index.meta.Description})
+	r.warmRenders(docs)
+
+	r.hash = hash
+	r.syncedAt = time.Now()
+	return nil
+}
+
+// emptyIndex is the index served for a repo without any documents.
+const emptyIndex = "# No content yet\n\nThere are no notes here yet. Check back after the next push.\n"
+
+// missingIndex is the index served for a repo with documents but no index.
+const missingIndex = "# Notes\n\nThis repo doesn't have an index page yet.\n"
+
+// problem reports something wrong with the repo's content. In strict mode
+// it fails the sync, otherwise it is logged and the sync carries on.
+func (r *repo) problem(err error) error {
+	if r.strict {
+		return err
+	}
+	r.logger.Printf("warning: %v\n", err)
+	return nil
+}
+
+func (r *repo) missingIndexError() error {
+	if r.opts.IndexFile != "" {
+		return fmt.Errorf("no index document %s found", r.opts.IndexFile)
+	}
+	return errors.New("no index document found")
+}
+
+// snapshot is what a sync reads from the repo at one hash.
+type snapshot struct {
+	docs      []*document
+	redirects []redirect
+	config    siteConfig
+}
+
+func (r *repo) fetchDocuments(ctx context.Context, hash string) (*snapshot, error) {
 	repoFS, cleanup, err := r.fp.Contents(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get contents: %w", err)
+		return nil, fmt.Errorf("failed to get contents: %w", err)
 	}
 	defer cleanup()
 
-	docs, err := r.extractDocuments(repoFS)
+	docs, err := r.extractDocuments(repoFS, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract documents: %w", err)
+	}
+
+	root, err := repoRoot(repoFS)
+	if err != nil {
+		return nil, err
+	}
+	redirects, err := readRedirects(root)
+	if err != nil {
+		return nil, err
+	}
+	config, err := readSiteConfig(root)
 	if err != nil {
-		return fmt.Errorf("failed to extract documents: %w", err)
+		if err := r.problem(err); err != nil {
+			return nil, err
+		}
 	}
 
-	return r.indexDocuments(docs)
+	return &snapshot{docs: docs, redirects: redirects, config: config}, nil
+}
+
+func (r *repo) Hash() string {
+	return r.hash
+}
+
+// SyncedAt returns when the repo last synced successfully, whether or not
+// that brought in a new hash.
+func (r *repo) SyncedAt() time.Time {
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
+	return r.syncedAt
 }
 
 func (r *repo) Index() *document {
 	return r.index
 }
 
+func (r *repo) Documents() map[string]*document {
+	return r.documents
+}
+
+// Recent returns up to n dated documents, newest first.
+func (r *repo) Recent(n int) []*document {
+	recent := make([]*document, 0, min(n, len(r.dated)))
+	for i := len(r.dated) - 1; i >= 0 && len(recent) < n; i-- {
+		recent = append(recent, r.dated[i])
+	}
+	return recent
+}
+
+// Archive returns the dated documents grouped by year and month, newest
+// first.
+func (r *repo) Archive() []archiveYear {
+	return r.archive
+}
+
+// Document looks up the document served at path. An exact match wins;
+// otherwise the path is matched ignoring case, and callers can compare the
+// document's served path to tell the two apart.
 func (r *repo) Document(path string) (*document, bool) {
-	doc, ok := r.documents[path]
+	if doc, ok := r.documents[path]; ok {
+		return doc, true
+	}
+	if r.isIndexKey(path) && r.index != nil {
+		return r.index, true
+	}
+	doc, ok := r.folded[strings.ToLower(path)]
 	return doc, ok
 }
 
+// isIndexKey reports whether links to key are links to the index.
+func (r *repo) isIndexKey(key string) bool {
+	return key == "README" || (key != "" && key == r.opts.indexKey())
+}
+
+// markdownExtensions are the file extensions treated as documents.
+var markdownExtensions = []string{".md", ".markdown"}
+
+// trimMarkdownExt strips a markdown extension from name, reporting whether
+// name had one.
+func trimMarkdownExt(name string) (string, bool) {
+	for _, ext := range markdownExtensions {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext), true
+		}
+	}
+	return name, false
+}
+
+// Neighbors returns the dated documents immediately before and after the
+// document served at path, or its neighbors in reading order when the repo
+// has an order file. Either is nil at the ends of the list or when the
+// document isn't dated.
+func (r *repo) Neighbors(path string) (prev, next *document) {
+	docs := r.dated
+	if r.ordered != nil {
+		docs = r.ordered
+	}
+
+	for i, d := range docs {
+		if d.servedPath() != path {
+			continue
+		}
+		if i > 0 {
+			prev = docs[i-1]
+		}
+		if i < len(docs)-1 {
+			next = docs[i+1]
+		}
+		break
+	}
+	return prev, next
+}
+
+// foldPaths indexes documents by their lowercased served path. When paths
+// differ only in case the first in sorted order wins.
+func foldPaths(documents map[string]*document) map[string]*document {
+	paths := make([]string, 0, len(documents))
+	for p := range documents {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	folded := make(map[string]*document, len(paths))
+	for _, p := range paths {
+		k := strings.ToLower(p)
+		if _, exists := folded[k]; !exists {
+			folded[k] = documents[p]
+		}
+	}
+	return folded
+}
+
 func (r *repo) indexDocuments(docs []*document) error {
+	r.index = nil
+	r.documents = make(map[string]*document)
+	r.dated = nil
+
 	for _, d := range docs {
-		if d.path == "README.md" {
-			r.index = d
+		p := d.servedPath()
+		if p == "README" {
+			if d.isIndex() {
+				r.index = d
+			} else {
+				r.logger.Printf("warning: %s isn't the index file %s, leaving it out\n", d.path, r.opts.IndexFile)
+			}
+			continue
+		}
+
+		// A folder's README is served at the folder's path, unless a
+		// document of the same name already claims it.
+		existing, exists := r.documents[p]
+		if exists && d.isLandingPage() {
+			continue
+		}
+
+		// A permalink beats a path from a filename. Between two
+		// permalinks the first document by path keeps it.
+		if exists && existing.permalink != "" {
+			if d.permalink != "" {
+				r.logger.Printf("warning: %s and %s both have the permalink %s, keeping %s\n", existing.path, d.path, p, existing.path)
+			}
 			continue
 		}
 
-		p := strings.TrimSuffix(d.path, ".md")
 		r.documents[p] = d
 	}
 
+	for _, d := range r.documents {
+		if !d.Date().IsZero() {
+			r.dated = append(r.dated, d)
+		}
+	}
+	r.folded = foldPaths(r.documents)
+	sort.Slice(r.dated, func(i, j int) bool {
+		a, b := r.dated[i], r.dated[j]
+		if !a.Date().Equal(b.Date()) {
+			return a.Date().Before(b.Date())
+		}
+		return a.path < b.path
+	})
+	r.archive = buildArchive(r.dated)
+	r.ordered = buildOrdered(r.documents)
+	r.tags = buildTags(r.documents, r.opts.basePath)
+
+	// Wiki links resolve against the whole set, so they can only be
+	// indexed once everything else is.
+	r.wiki = buildWikiIndex(docs)
+	r.backlinks = r.buildBacklinks(docs, r.wiki)
+
 	if r.index == nil {
-		return fmt.Errorf("no index document found")
+		return r.missingIndexError()
+	}
+
+	r.brokenLinks = r.findBrokenLinks(docs, r.wiki)
+	for _, b := range r.brokenLinks {
+		r.logger.Printf("broken link in %s to %s\n", b.Source, b.Target)
+	}
+	if len(r.brokenLinks) > 0 {
+		err := fmt.Errorf("found %d broken links", len(r.brokenLinks))
+		if r.failOnBrokenLinks {
+			return err
+		}
+		if err := r.problem(err); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (r *repo) extractDocuments(repo fs.FS) ([]*document, error) {
+// Render renders d, one of the repo's documents, resolving its wiki links
+// against the repo's.
+func (r *repo) Render(d *document) ([]byte, error) {
+	return d.Render(r.wiki)
+}
+
+// warmRenders renders every document up front so the first visitor to a
+// page doesn't pay the markdown parse cost, and returns how many it
+// rendered. A document that fails to render is logged and left to fail
+// again when requested.
+//
+// Nothing is warmed without a cache to keep the renders. With only the
+// in-memory cache, warming stops once it has rendered the cache's budget,
+// since any more would evict what it just warmed.
+func (r *repo) warmRenders(docs []*document) int {
+	if r.opts.cache == nil && r.opts.diskCache == nil {
+		return 0
+	}
+	budget := int64(-1)
+	if r.opts.diskCache == nil {
+		budget = r.opts.cache.maxBytes
+	}
+
+	var warmedBytes, warmed atomic.Int64
+	errs := renderAll(docs, r.renderConcurrency, func(d *document) error {
+		if budget >= 0 && warmedBytes.Load() >= budget {
+			return nil
+		}
+		b, err := r.Render(d)
+		warmedBytes.Add(int64(len(b)))
+		warmed.Add(1)
+		return err
+	})
+	for _, err := range errs {
+		r.logger.Printf("%v\n", err)
+	}
+	if len(errs) > 0 {
+		r.logger.Printf("failed to render %d of %d documents\n", len(errs), len(docs))
+	}
+	if n := int(warmed.Load()); n < len(docs) {
+		r.logger.Printf("render cache full, warmed %d of %d documents\n", n, len(docs))
+	}
+	return int(warmed.Load())
+}
+
+// renderAll calls render for every document, at most limit at a time or
+// GOMAXPROCS when limit is zero. A failure doesn't stop the others; the
+// errors are returned in the order of docs.
+func renderAll(docs []*document, limit int, render func(*document) error) []error {
+	if limit <= 0 {
+		limit = runtime.GOMAXPROCS(0)
+	}
+	g := new(errgroup.Group)
+	g.SetLimit(limit)
+
+	failed := make([]error, len(docs))
+	for i, d := range docs {
+		g.Go(func() error {
+			if err := render(d); err != nil {
+				failed[i] = fmt.Errorf("failed to render %s: %w", d.path, err)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	var errs []error
+	for _, err := range failed {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// archiveRoot finds the directory an archive wraps the repo in. GitHub
+// zipballs put everything under a single owner-name-hash directory; an
+// archive with files at its root, or several top-level entries, is
+// already the repo.
+func archiveRoot(archive fs.FS) (string, error) {
+	entries, err := fs.ReadDir(archive, ".")
+	if err != nil {
+		return "", fmt.Errorf("failed to read archive root: %w", err)
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return entries[0].Name(), nil
+	}
+	return ".", nil
+}
+
+// repoRoot returns the repo inside archive, below its top-level directory.
+func repoRoot(archive fs.FS) (fs.FS, error) {
+	root, err := archiveRoot(archive)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := fs.Sub(archive, root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", root, err)
+	}
+	return repo, nil
+}
+
+func (r *repo) extractDocuments(archive fs.FS, hash string) ([]*document, error) {
+	repo, err := repoRoot(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	ignore, err := readIgnore(repo)
+	if err != nil {
+		return nil, err
+	}
+	ignore = append(ignore, r.exclude...)
+
 	var documents []*document
-	err := fs.WalkDir(repo, ".", func(path string, d fs.DirEntry, err error) error {
+	err = fs.WalkDir(repo, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return fmt.Errorf("failed to walk dir: %w", err)
 		}
 		if d.IsDir() {
 			return nil
 		}
-		if d.Name() != "README.md" && !strings.HasSuffix(d.Name(), ".md") {
+		if _, ok := trimMarkdownExt(d.Name()); !ok {
+			return nil
+		}
+
+		if len(r.include) > 0 && !r.include.Matches(path) {
+			if name, _ := trimMarkdownExt(path); name != "README" && path != r.opts.IndexFile {
+				return nil
+			}
+		}
+		if ignore.Ignored(path) {
 			return nil
 		}
 
@@ -93,14 +564,18 @@ func (r *repo) extractDocuments(repo fs.FS) ([]*document, error) {
 			return fmt.Errorf("failed to read file: %w", err)
 		}
 
-		p := strings.Split(path, string(filepath.Separator))
-		p = p[1:]
-		path = strings.Join(p, string(filepath.Separator))
-
-		document, err := newDocument(path, contents)
+		document, err := newDocument(path, hash, contents, r.opts)
 		if err != nil {
 			return fmt.Errorf("failed to create document: %w", err)
 		}
+		for _, p := range document.problems {
+			if err := r.problem(fmt.Errorf("%s: %w", path, p)); err != nil {
+				return err
+			}
+		}
+		if r.opts.InlineImages {
+			document.loadImages(repo, localImages(document.parse(), path))
+		}
 
 		documents = append(documents, document)
 
@@ -110,5 +585,27 @@ func (r *repo) extractDocuments(repo fs.FS) ([]*document, error) {
 		return nil, fmt.Errorf("failed to walk fs: %w", err)
 	}
 
+	order, err := readOrder(repo)
+	if err != nil {
+		return nil, err
+	}
+	r.applyOrder(documents, order)
+
 	return documents, nil
 }
+
+// readIgnore loads the ignore file from the root of the repo. A missing
+// file ignores nothing.
+func readIgnore(repo fs.FS) (ignoreRules, error) {
+	matches, err := fs.Glob(repo, ignoreFile)
+	if err != nil || len(matches) == 0 {
+		return nil, nil
+	}
+
+	b, err := fs.ReadFile(repo, matches[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ignoreFile, err)
+	}
+
+	return parseIgnore(b), nil
+}
@@ -0,0 +1,465 @@
+package thoughts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func benchmarkFiles() map[string]string {
+	var body strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&body, "## Section %d\n\nSome *text* with a [link](./other.md) and `code`.\n\n", i)
+	}
+
+	return map[string]string{
+		"README.md":       "# Index",
+		"thoughts/big.md": body.String(),
+	}
+}
+
+func benchmarkFirstRequest(b *testing.B, warm bool) {
+	logger := log.New(io.Discard, "", 0)
+	fp := &fakeProvider{hash: "abc123", files: newTestFS(benchmarkFiles())}
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		r := newRepo(logger, fp, RenderOptions{cache: newRenderCache(1 << 20)})
+		repoFS, _, _ := fp.Contents(context.Background())
+		docs, err := r.extractDocuments(repoFS, fp.hash)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := r.indexDocuments(docs); err != nil {
+			b.Fatal(err)
+		}
+		if warm {
+			r.warmRenders(docs)
+		}
+		s := &Site{logger: logger, tpl: mustParseWrapper(b)}
+		s.active.Store(r)
+		req := httptest.NewRequest(http.MethodGet, "/thoughts/big", nil)
+		rec := httptest.NewRecorder()
+		b.StartTimer()
+
+		s.ServeHTTP(rec, req)
+	}
+}
+
+func BenchmarkFirstRequestCold(b *testing.B) {
+	benchmarkFirstRequest(b, false)
+}
+
+func BenchmarkFirstRequestWarmed(b *testing.B) {
+	benchmarkFirstRequest(b, true)
+}
+
+func TestRepoWarmRenders(t *testing.T) {
+	files := map[string]string{"README.md": "# Index"}
+	for i := range 20 {
+		files[fmt.Sprintf("notes/%d.md", i)] = "# Note\n\n" + strings.Repeat("Some text to render. ", 50)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		cache *renderCache
+		want  func(warmed, docs int) bool
+	}{
+		{"no cache", nil, func(warmed, docs int) bool { return warmed == 0 }},
+		{"small cache", newRenderCache(3000), func(warmed, docs int) bool { return warmed > 0 && warmed < docs }},
+		{"large cache", newRenderCache(1 << 20), func(warmed, docs int) bool { return warmed == docs }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRepo(log.New(io.Discard, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{cache: tt.cache})
+			r.renderConcurrency = 1
+			if err := r.Sync(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			docs := slices.Collect(maps.Values(r.Documents()))
+			if warmed := r.warmRenders(docs); !tt.want(warmed, len(docs)) {
+				t.Errorf("unexpected %d of %d documents warmed", warmed, len(docs))
+			}
+		})
+	}
+}
+
+func TestRepoMarkdownExtensions(t *testing.T) {
+	r := newTestRepo(t, map[string]string{
+		"README.markdown":      "# Index",
+		"thoughts/a.md":        "# A",
+		"thoughts/b.markdown":  "# B",
+		"thoughts/notes.txt":   "not a document",
+		"thoughts/c.markdownx": "not a document either",
+	})
+
+	if r.Index() == nil || r.Index().path != "README.markdown" {
+		t.Fatalf("expected README.markdown to be the index, got %+v", r.Index())
+	}
+	for _, p := range []string{"thoughts/a", "thoughts/b"} {
+		if _, ok := r.Document(p); !ok {
+			t.Errorf("expected document %s", p)
+		}
+	}
+	if len(r.documents) != 2 {
+		t.Errorf("expected 2 documents, got %d", len(r.documents))
+	}
+}
+
+func newTestRepo(t *testing.T, files map[string]string) *repo {
+	t.Helper()
+
+	r := newRepo(log.New(io.Discard, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{})
+	if err := r.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRepoNeighbors(t *testing.T) {
+	r := newTestRepo(t, map[string]string{
+		"README.md":                       "# Index",
+		"about.md":                        "# Not dated",
+		"thoughts/2024-03-15.md":          "# March",
+		"thoughts/2023-12-31.md":          "# December",
+		"thoughts/2024-01-02-new-year.md": "# January",
+	})
+
+	tests := []struct {
+		path       string
+		prev, next string
+	}{
+		{"thoughts/2023-12-31", "", "thoughts/2024-01-02-new-year"},
+		{"thoughts/2024-01-02-new-year", "thoughts/2023-12-31", "thoughts/2024-03-15"},
+		{"thoughts/2024-03-15", "thoughts/2024-01-02-new-year", ""},
+		{"about", "", ""},
+	}
+
+	for _, tt := range tests {
+		prev, next := r.Neighbors(tt.path)
+		if got := servedPathOrEmpty(prev); got != tt.prev {
+			t.Errorf("%s: expected prev %q, got %q", tt.path, tt.prev, got)
+		}
+		if got := servedPathOrEmpty(next); got != tt.next {
+			t.Errorf("%s: expected next %q, got %q", tt.path, tt.next, got)
+		}
+	}
+}
+
+func servedPathOrEmpty(d *document) string {
+	if d == nil {
+		return ""
+	}
+	return d.servedPath()
+}
+
+// blockingProvider counts Contents calls and holds each one until release
+// is closed, so concurrent syncs overlap.
+type blockingProvider struct {
+	fakeProvider
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (b *blockingProvider) Contents(ctx context.Context) (fs.FS, func(), error) {
+	b.calls.Add(1)
+	<-b.release
+	return b.fakeProvider.Contents(ctx)
+}
+
+func TestRepoSyncDeduplicatesConcurrentFetches(t *testing.T) {
+	fp := &blockingProvider{
+		fakeProvider: fakeProvider{hash: "abc123", files: newTestFS(map[string]string{"README.md": "# Index"})},
+		release:      make(chan struct{}),
+	}
+	logger := log.New(io.Discard, "", 0)
+	a := newRepo(logger, fp, RenderOptions{})
+	b := newRepo(logger, fp, RenderOptions{})
+	b.flight = a.flight
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 6)
+	for _, r := range []*repo{a, a, a, b, b, b} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- r.Sync(context.Background())
+		}()
+	}
+
+	// Give every goroutine a chance to join the in-flight fetch.
+	time.Sleep(50 * time.Millisecond)
+	close(fp.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := fp.calls.Load(); n != 1 {
+		t.Fatalf("expected Contents to be called once, got %d", n)
+	}
+	for _, r := range []*repo{a, b} {
+		if r.Index() == nil || r.Hash() != "abc123" {
+			t.Errorf("expected repo to be synced, got hash %q", r.Hash())
+		}
+	}
+}
+
+func TestRepoSyncEmpty(t *testing.T) {
+	for name, files := range map[string]map[string]string{
+		"no files":    {},
+		"no markdown": {"image.png": "png"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var logs bytes.Buffer
+			fp := &fakeProvider{hash: "abc123", files: newTestFS(files)}
+			r := newRepo(log.New(&logs, "", 0), fp, RenderOptions{})
+			if err := r.Sync(context.Background()); err != nil {
+				t.Fatalf("expected an empty repo to sync, got %v", err)
+			}
+			if !strings.Contains(logs.String(), "no markdown documents") {
+				t.Errorf("expected a warning, got %q", logs.String())
+			}
+			if got := r.Index().Title(); got != "No content yet" {
+				t.Errorf("expected the placeholder index, got %q", got)
+			}
+
+			// Content pushed later replaces the placeholder.
+			fp.hash = "def456"
+			fp.files = fstest.MapFS{"owner-name-def456/README.md": {Data: []byte("# Notes")}}
+			if err := r.Sync(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if got := r.Index().Title(); got != "Notes" {
+				t.Errorf("expected the new index, got %q", got)
+			}
+		})
+	}
+}
+
+func TestRepoSyncMissingIndex(t *testing.T) {
+	files := map[string]string{"about.md": "# About", "notes/a.md": "[gone](missing)"}
+
+	strict := newRepo(log.New(io.Discard, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{})
+	strict.strict = true
+	if err := strict.Sync(context.Background()); err == nil || !strings.Contains(err.Error(), "no index document found") {
+		t.Fatalf("expected documents without an index to fail in strict mode, got %v", err)
+	}
+
+	var logs bytes.Buffer
+	lenient := newRepo(log.New(&logs, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{})
+	if err := lenient.Sync(context.Background()); err != nil {
+		t.Fatalf("expected documents without an index to sync, got %v", err)
+	}
+	if got := lenient.Index().Title(); got != "Notes" {
+		t.Errorf("expected the placeholder index, got %q", got)
+	}
+	if _, ok := lenient.Document("about"); !ok {
+		t.Error("expected the other documents to be served")
+	}
+	for _, want := range []string{"warning: no index document found", "warning: found 1 broken links"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("expected %q in the logs, got %q", want, logs.String())
+		}
+	}
+
+	// With an index, strict mode still fails on the broken link.
+	files["README.md"] = "# Index"
+	strict = newRepo(log.New(io.Discard, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{})
+	strict.strict = true
+	if err := strict.Sync(context.Background()); err == nil || !strings.Contains(err.Error(), "found 1 broken links") {
+		t.Fatalf("expected broken links to fail in strict mode, got %v", err)
+	}
+}
+
+func TestRepoSyncBadFrontmatter(t *testing.T) {
+	files := map[string]string{"README.md": "# Index", "a.md": "---\ntitle: [\n---\n# A\n"}
+
+	var logs bytes.Buffer
+	lenient := newRepo(log.New(&logs, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{})
+	if err := lenient.Sync(context.Background()); err != nil {
+		t.Fatalf("expected bad frontmatter to sync, got %v", err)
+	}
+	if d, ok := lenient.Document("a"); !ok || d.Title() != "A" {
+		t.Errorf("expected a to be served without its metadata, got %v", d)
+	}
+	if !strings.Contains(logs.String(), "warning: a.md: failed to parse frontmatter") {
+		t.Errorf("expected a warning, got %q", logs.String())
+	}
+
+	strict := newRepo(log.New(io.Discard, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{})
+	strict.strict = true
+	if err := strict.Sync(context.Background()); err == nil || !strings.Contains(err.Error(), "failed to parse frontmatter") {
+		t.Fatalf("expected bad frontmatter to fail in strict mode, got %v", err)
+	}
+}
+
+func TestRepoSyncEmptyStrict(t *testing.T) {
+	r := newRepo(log.New(io.Discard, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(map[string]string{})}, RenderOptions{})
+	r.strict = true
+	if err := r.Sync(context.Background()); err == nil || !strings.Contains(err.Error(), "no markdown documents") {
+		t.Fatalf("expected an empty repo to fail in strict mode, got %v", err)
+	}
+}
+
+func TestArchiveRoot(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive fstest.MapFS
+		want    string
+	}{
+		{"github zipball", newTestFS(map[string]string{"README.md": "# Index", "notes/a.md": "# A"}), "owner-name-abc123"},
+		{"flat", fstest.MapFS{"README.md": {Data: []byte("# Index")}, "notes/a.md": {Data: []byte("# A")}}, "."},
+		{"single file", fstest.MapFS{"README.md": {Data: []byte("# Index")}}, "."},
+		{"empty", fstest.MapFS{}, "."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := archiveRoot(tt.archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepoSyncFlatArchive(t *testing.T) {
+	files := fstest.MapFS{
+		"README.md":       {Data: []byte("# Index")},
+		"notes/idea.md":   {Data: []byte("# Idea")},
+		".thoughtsignore": {Data: []byte("drafts/\n")},
+		"drafts/wip.md":   {Data: []byte("# WIP")},
+	}
+	r := newRepo(log.New(io.Discard, "", 0), &fakeProvider{hash: "abc123", files: files}, RenderOptions{})
+	if err := r.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Index() == nil || r.Index().Title() != "Index" {
+		t.Fatal("expected the root README as the index")
+	}
+	if _, ok := r.documents["notes/idea"]; !ok {
+		t.Errorf("expected notes/idea to keep its path, got %v", r.documents)
+	}
+	if _, ok := r.documents["drafts/wip"]; ok {
+		t.Error("expected the ignore file at the archive root to apply")
+	}
+}
+
+func TestRepoDocumentExactMatchWins(t *testing.T) {
+	r := newTestRepo(t, map[string]string{
+		"README.md": "# Index",
+		"Foo.md":    "# Upper",
+		"foo.md":    "# Lower",
+	})
+
+	for path, want := range map[string]string{"Foo": "Upper", "foo": "Lower", "FOO": "Upper"} {
+		d, ok := r.Document(path)
+		if !ok || d.Title() != want {
+			t.Errorf("expected %s to resolve to %q", path, want)
+		}
+	}
+}
+
+func TestRepoIndexFile(t *testing.T) {
+	var logs bytes.Buffer
+	files := map[string]string{
+		"index.md":       "# Home\n\nSee [about](about.md).",
+		"README.md":      "# For GitHub",
+		"about.md":       "# About\n\nBack [home](index.md).",
+		"notes/index.md": "# Not the index",
+	}
+	r := newRepo(log.New(&logs, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{IndexFile: "index.md"})
+	if err := r.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Index() == nil || r.Index().Title() != "Home" {
+		t.Fatal("expected index.md to be the index")
+	}
+	if _, ok := r.documents["index"]; ok {
+		t.Error("expected the index to stay out of the documents")
+	}
+	if _, ok := r.documents["notes/index"]; !ok {
+		t.Error("expected only the root index.md to be the index")
+	}
+	if !strings.Contains(logs.String(), "README.md isn't the index file index.md") {
+		t.Errorf("expected the root README to be left out with a warning, got %q", logs.String())
+	}
+	if len(r.BrokenLinks()) != 0 {
+		t.Errorf("expected links to index.md to resolve, got %v", r.BrokenLinks())
+	}
+	if d, ok := r.Document("index"); !ok || d != r.Index() {
+		t.Error("expected the index file's path to find the index")
+	}
+	if got := r.Backlinks("README"); len(got) != 1 || got[0].path != "about.md" {
+		t.Errorf("expected about.md to link to the index, got %v", got)
+	}
+}
+
+func TestRepoIndexFileMissing(t *testing.T) {
+	r := newRepo(log.New(io.Discard, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(map[string]string{"README.md": "# Index"})}, RenderOptions{IndexFile: "home.md"})
+	r.strict = true
+	err := r.Sync(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "no index document home.md found") {
+		t.Fatalf("expected a missing index file error, got %v", err)
+	}
+}
+
+func TestRenderAllConcurrency(t *testing.T) {
+	var docs []*document
+	for i := 0; i < 12; i++ {
+		docs = append(docs, &document{path: fmt.Sprintf("doc%d.md", i)})
+	}
+
+	var mu sync.Mutex
+	var running, peak, rendered int
+	errs := renderAll(docs, 3, func(d *document) error {
+		mu.Lock()
+		running++
+		rendered++
+		peak = max(peak, running)
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if d.path == "doc4.md" || d.path == "doc9.md" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	if peak > 3 {
+		t.Errorf("expected at most 3 renders at once, got %d", peak)
+	}
+	if rendered != len(docs) {
+		t.Errorf("expected every document to be rendered despite failures, got %d", rendered)
+	}
+	if len(errs) != 2 || !strings.Contains(errs[0].Error(), "doc4.md") || !strings.Contains(errs[1].Error(), "doc9.md") {
+		t.Errorf("expected the two failures in order, got %v", errs)
+	}
+}
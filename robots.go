@@ -0,0 +1,33 @@
+package thoughts
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// robotsModes are the -robots values and the robots.txt each serves.
+var robotsModes = map[string]string{
+	"allow":    "User-agent: *\nAllow: /\n",
+	"disallow": "User-agent: *\nDisallow: /\n",
+}
+
+func validRobotsMode(mode string) error {
+	if _, ok := robotsModes[mode]; !ok {
+		return fmt.Errorf("unknown robots mode %q, use allow or disallow", mode)
+	}
+	return nil
+}
+
+// robotsTxt is the robots.txt for the site's -robots mode.
+func (s *Site) robotsTxt() string {
+	body, ok := robotsModes[s.robots]
+	if !ok {
+		return robotsModes["allow"]
+	}
+	return body
+}
+
+func (s *Site) serveRobots(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte(s.robotsTxt()))
+}
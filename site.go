@@ -1,157 +1,934 @@
-package main
+package thoughts
 
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/tdewolff/minify/v2"
+	"golang.org/x/crypto/acme/autocert"
 	"golang.org/x/sync/errgroup"
 )
 
-const wrapper = `
-<!DOCTYPE html>
-<html>
-	<head>
-		<title>{{.Title}}</title>
-		<style type="text/css">
-			body {
-				font-family: monospace;
-			}
-			.content {
-				margin: 0 auto;
-				width: 800px;
-				border: 1px solid #888;
-				padding: 20px;
-				box-shadow: 2px 2px #ccc;
-			}
-		</style>
-	</head>
-	<body>
-		<div class="content">
-			{{.Body}}
-		</div>
-	</body>
-</html>
-`
-
-type site struct {
+// wrapper is the built-in page template. It pulls in the built-in styles,
+// the "style" and "print-style" templates, unless -css is given.
+//
+//go:embed wrapper.html
+var wrapper string
+
+// defaultCSS and printCSS are the built-in styles, parsed as templates so
+// they can use the page data.
+var (
+	//go:embed style.css
+	defaultCSS string
+
+	//go:embed print.css
+	printCSS string
+)
+
+// pageData is what the wrapper template is executed with.
+type pageData struct {
+	Title string
+	Body  template.HTML
+
+	// PageTitle, Description and URL describe the document for link
+	// previews. URL is only set when the site has a base URL.
+	PageTitle   string
+	Description string
+	URL         string
+
+	CSS          template.CSS
+	ContentWidth string
+	Favicon      string
+	Feed         string
+	Analytics    template.HTML
+	Nav          []*navItem
+
+	// Nonce is put on the page's inline scripts so the CSP lets them
+	// run. It is empty for exported pages, which are served without one.
+	Nonce string
+
+	ThemeToggle bool
+	Mermaid     bool
+	Math        bool
+	CopyCode    bool
+
+	Breadcrumbs []pageLink
+	Prev, Next  *pageLink
+
+	// ReadingTime is the estimate shown above a note, if any.
+	ReadingTime string
+
+	// Backlinks are the notes linking to this one.
+	Backlinks []pageLink
+}
+
+// pageLink is a link rendered by the template outside the document body.
+// Breadcrumbs leave URL empty for the current page and for folders without
+// a landing page.
+type pageLink struct {
+	Name string
+	URL  string
+}
+
+// Site serves the notes of a repo, or of several mounted under their own
+// paths, and keeps them in sync with it.
+type Site struct {
 	title              string
+	css                template.CSS
+	noNav              bool
+	themeToggle        bool
+	baseURL            string
+	basePath           string
+	tlsCert, tlsKey    string
+	autocert           *autocert.Manager
+	autocertDomains    []string
+	basicAuthUser      string
+	basicAuthPass      string
+	adminToken         string
+	accessLog          bool
+	noReadingTime      bool
+	minifier           *minify.M
+	robots             string
+	favicon            favicon
+	startupRetries     int
+	corsOrigins        []string
+	contentWidth       string
+	trustedProxies     []netip.Prefix
+	rateLimiter        *rateLimiter
+	csp                string
+	analytics          template.HTML
+	autoindex          bool
+	startupRetryDelay  time.Duration
+	shutdownTimeout    time.Duration
 	logger             *log.Logger
-	activeRepo         *repo
 	versionA, versionB *repo
 	tpl                *template.Template
+
+	// pageFingerprint covers what a page depends on besides the repo
+	// hash, so its ETag changes after an upgrade or a change of flags.
+	pageFingerprint string
+
+	// active is the buffer being served, versionA or versionB. Swaps
+	// happen on request goroutines too, through /admin/sync.
+	active atomic.Pointer[repo]
+
+	// swapMu keeps the ticker and manual syncs from swapping at once.
+	swapMu sync.Mutex
+
+	// syncs records the outcome of every sync for the status page.
+	syncs syncRecorder
+
+	// contents is the provider both buffers sync from, sharing each
+	// download between them.
+	contents *sharedContents
+
+	// mounts are the sites of each repo when serving several, in which
+	// case this site has no repo of its own.
+	mounts []*Site
 }
 
-func newSite(logger *log.Logger, repoURL, siteTitle string, useCache bool) (*site, error) {
-	logger.Printf("creating site for %s\n", repoURL)
+// Options holds the settings a Site is created with, usually from the
+// flags of cmd/thoughts.
+type Options struct {
+	// RepoURL is the repo to serve, such as josebalius/notes or a full
+	// url, unless Mounts is set.
+	RepoURL string
+
+	// SiteTitle names the site unless the repo sets a title in
+	// _config.yml or the index's frontmatter.
+	SiteTitle string
+
+	UseCache bool
+	NoNav    bool
+	Render   RenderOptions
+
+	// Logger is where the site logs to, the standard logger if nil.
+	Logger *log.Logger
+
+	// ThemeToggle adds a light/dark switch that remembers the reader's
+	// choice, defaulting to their OS preference.
+	ThemeToggle bool
+
+	// BaseURL is the public origin of the site, used to build absolute
+	// URLs.
+	BaseURL string
+
+	// TemplatePath, when set, replaces the built-in wrapper template.
+	TemplatePath string
+
+	// CSSPath, when set, replaces the built-in styles with the contents of
+	// the file.
+	CSSPath string
+
+	// MaxZipSize caps the downloaded zipball and each file in it, in bytes.
+	MaxZipSize int64
+
+	// DownloadRetries is how many times a zipball download that fails on
+	// the network or with a server error is started over.
+	DownloadRetries int
+
+	// RenderCacheSize is the byte budget for rendered documents kept in
+	// memory. Zero disables the cache.
+	RenderCacheSize int64
+
+	// ShutdownTimeout bounds how long in-flight requests get to finish
+	// once the server is stopped. Zero waits indefinitely.
+	ShutdownTimeout time.Duration
+
+	// BasePath mounts the site under a path prefix, such as "/docs", for
+	// serving behind a reverse proxy.
+	BasePath string
+
+	// TLSCert and TLSKey are PEM file paths. When set, the site is served
+	// over HTTPS.
+	TLSCert, TLSKey string
+
+	// AutocertDomains, when set, serves HTTPS on :443 with certificates
+	// from Let's Encrypt for these hosts, cached in AutocertCacheDir, and
+	// redirects HTTP on :80.
+	AutocertDomains  []string
+	AutocertCacheDir string
+
+	// BasicAuthUser and BasicAuthPass, when set, are required on every
+	// request except probes, and /admin/sync when AdminToken is set.
+	BasicAuthUser, BasicAuthPass string
+
+	// AdminToken, when set, is the bearer token POST /admin/sync takes in
+	// place of basic auth. With neither set the endpoint is not served.
+	AdminToken string
+
+	// AccessLog logs every request with its status, size and duration.
+	AccessLog bool
+
+	// Mounts serves several repos, each under its own path, instead of
+	// RepoURL.
+	Mounts []Mount
+
+	// Provider, when set, is where the repo's files come from in place of
+	// a GitHub client for RepoURL, which then only names the repo in logs
+	// and the disk cache.
+	Provider FileProvider
+
+	// ProviderName is where RepoURL is hosted: github, the default,
+	// gitlab, or git for any remote git can clone. GitLabToken
+	// authenticates to GitLab for private repos.
+	ProviderName string
+	GitLabToken  string
+
+	// GitDir is where the git provider clones repos to, if empty a
+	// temporary directory removed when Serve returns. GitDepth is how
+	// many commits it fetches, 0 for the whole history. GitToken and
+	// GitSSHKey authenticate to HTTPS and SSH remotes.
+	GitDir    string
+	GitDepth  int
+	GitToken  string
+	GitSSHKey string
 
-	var fp fileProvider
+	// NoReadingTime hides the reading time estimate above notes.
+	NoReadingTime bool
 
-	ghclient, err := newGitHubClient(logger, githubAPI, repoURL)
+	// FailOnBrokenLinks fails a sync when a note links to a missing one.
+	FailOnBrokenLinks bool
+
+	// Strict fails a sync, and so startup, when the repo has no documents,
+	// no index document or broken links, rather than logging a warning.
+	Strict bool
+
+	// RenderConcurrency bounds the documents rendered at once after a
+	// sync. Zero means GOMAXPROCS.
+	RenderConcurrency int
+
+	// Minify collapses whitespace and strips comments from served pages.
+	Minify bool
+
+	// Robots picks the robots.txt served, see robotsModes. Empty allows
+	// crawling.
+	Robots string
+
+	// FaviconPath is the icon served at /favicon.ico. Empty serves the
+	// built-in one.
+	FaviconPath string
+
+	// StartupRetries is how many more times the first sync is tried before
+	// Serve gives up.
+	StartupRetries int
+
+	// Exclude are globs of repo paths to skip, following .thoughtsignore
+	// rules and applied after them.
+	Exclude []string
+
+	// Include, when set, limits the site to the repo paths matching one of
+	// these globs. Exclusions still apply on top.
+	Include []string
+
+	// CORSOrigins are the origins allowed to call the JSON API from a
+	// browser, or "*" for any.
+	CORSOrigins []string
+
+	// ExportDir, when set, writes the site as static html into the
+	// directory instead of serving it.
+	ExportDir string
+
+	// RenderDiskCache, when set, is the directory rendered documents are
+	// kept in across restarts, one folder per repo.
+	RenderDiskCache string
+
+	// ContentWidth is the CSS width of the content column, such as 800px or
+	// 90%. Empty uses DefaultContentWidth.
+	ContentWidth string
+
+	// TrustedProxies are the CIDRs whose X-Forwarded-For and X-Real-IP
+	// headers are believed when finding the client's address.
+	TrustedProxies []string
+
+	// RateLimit is how many requests per second each client may make, with
+	// bursts of up to RateBurst. Zero turns limiting off.
+	RateLimit float64
+	RateBurst int
+
+	// CSP replaces the built-in Content-Security-Policy, "off" drops it.
+	CSP string
+
+	// AnalyticsSnippet is html added to the end of every page, or a path
+	// to a file of it.
+	AnalyticsSnippet string
+
+	// Autoindex generates a listing for folders without a landing page.
+	Autoindex bool
+}
+
+// DefaultContentWidth is the content column's width when none is set.
+const DefaultContentWidth = "800px"
+
+// cssWidthRE matches the CSS lengths and percentages -content-width takes.
+var cssWidthRE = regexp.MustCompile(`^\d+(\.\d+)?(px|em|rem|ch|vw|%)$`)
+
+// NewSite creates a site for the repo, or mounts, opts describes. Nothing
+// is fetched until Sync or Serve is called.
+func NewSite(opts Options) (*Site, error) {
+	if opts.RepoURL == "" && len(opts.Mounts) == 0 {
+		return nil, errors.New("repo url is required")
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	return newSite(logger, opts)
+}
+
+func newSite(logger *log.Logger, opts Options) (*Site, error) {
+	if (opts.TLSCert == "") != (opts.TLSKey == "") {
+		return nil, errors.New("tls cert and key must be set together")
+	}
+	if (opts.BasicAuthUser == "") != (opts.BasicAuthPass == "") {
+		return nil, errors.New("basic auth user and pass must be set together")
+	}
+	if len(opts.AutocertDomains) > 0 && opts.TLSCert != "" {
+		return nil, errors.New("autocert and a tls cert cannot be used together, pick one")
+	}
+	if opts.Render.InlineImages && opts.ExportDir == "" {
+		return nil, errors.New("inline images only work when exporting")
+	}
+	if opts.Render.IndexFile == "README.md" {
+		opts.Render.IndexFile = "" // any README at the root
+	}
+	if f := opts.Render.IndexFile; f != "" {
+		if _, ok := trimMarkdownExt(f); !ok || strings.ContainsAny(f, `/\`) {
+			return nil, fmt.Errorf("invalid index file %q, use a markdown file at the repo root such as index.md", f)
+		}
+	}
+	if opts.ContentWidth == "" {
+		opts.ContentWidth = DefaultContentWidth
+	}
+	if !cssWidthRE.MatchString(opts.ContentWidth) {
+		return nil, fmt.Errorf("invalid content width %q, use a length such as 800px, 60em or 90%%", opts.ContentWidth)
+	}
+	proxies, err := parseTrustedProxies(opts.TrustedProxies)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create github client: %w", err)
+		return nil, err
+	}
+	if opts.Robots == "" {
+		opts.Robots = "allow"
+	}
+	if err := validRobotsMode(opts.Robots); err != nil {
+		return nil, err
+	}
+	if len(opts.Mounts) > 0 && opts.UseCache {
+		return nil, errors.New("the cache only supports a single repo")
 	}
+
+	t, err := parseTemplate(opts.TemplatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var css []byte
+	if opts.CSSPath != "" {
+		css, err = os.ReadFile(opts.CSSPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read css %s: %w", opts.CSSPath, err)
+		}
+	}
+
+	icon, err := loadFavicon(opts.FaviconPath)
+	if err != nil {
+		return nil, err
+	}
+
+	analytics, err := loadSnippet(opts.AnalyticsSnippet)
+	if err != nil {
+		return nil, err
+	}
+
+	var m *autocert.Manager
+	if len(opts.AutocertDomains) > 0 {
+		m = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(opts.AutocertDomains...),
+			Cache:      autocert.DirCache(opts.AutocertCacheDir),
+		}
+	}
+
+	opts.Render.basePath = cleanBasePath(opts.BasePath)
+	s := &Site{
+		title:           opts.SiteTitle,
+		css:             template.CSS(css),
+		noNav:           opts.NoNav,
+		themeToggle:     opts.ThemeToggle,
+		baseURL:         strings.TrimSuffix(opts.BaseURL, "/"),
+		basePath:        opts.Render.basePath,
+		tlsCert:         opts.TLSCert,
+		tlsKey:          opts.TLSKey,
+		autocert:        m,
+		autocertDomains: opts.AutocertDomains,
+		basicAuthUser:   opts.BasicAuthUser,
+		basicAuthPass:   opts.BasicAuthPass,
+		adminToken:      opts.AdminToken,
+		accessLog:       opts.AccessLog,
+		noReadingTime:   opts.NoReadingTime,
+		robots:          opts.Robots,
+		favicon:         icon,
+		startupRetries:  opts.StartupRetries,
+		corsOrigins:     opts.CORSOrigins,
+		contentWidth:    opts.ContentWidth,
+		trustedProxies:  proxies,
+		analytics:       analytics,
+		autoindex:       opts.Autoindex,
+		shutdownTimeout: opts.ShutdownTimeout,
+		logger:          logger,
+		tpl:             t,
+	}
+	s.pageFingerprint = pageFingerprint(s, opts)
+
+	if opts.Minify {
+		s.minifier = newMinifier()
+	}
+	if opts.RateLimit > 0 {
+		s.rateLimiter = newRateLimiter(opts.RateLimit, opts.RateBurst)
+	}
+	switch {
+	case opts.CSP != "":
+		s.csp = opts.CSP
+	case opts.Render.AllowRawHTML:
+		s.csp = rawHTMLCSP
+	default:
+		s.csp = defaultCSP
+	}
+
+	if len(opts.Mounts) > 0 {
+		s.mounts, err = newMounts(logger, opts)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	logger.Printf("creating site for %s\n", opts.RepoURL)
+
+	fp, name, err := newFileProvider(logger, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.RenderCacheSize > 0 {
+		opts.Render.cache = newRenderCache(opts.RenderCacheSize)
+	}
+	if opts.RenderDiskCache != "" {
+		opts.Render.diskCache, err = newDiskRenderCache(filepath.Join(opts.RenderDiskCache, name))
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.contents = newSharedContents(fp)
+	repoA := newRepo(logger, s.contents, opts.Render)
+	repoB := newRepo(logger, s.contents, opts.Render)
+	repoB.flight = repoA.flight // both buffers download from the same provider
+	repoA.failOnBrokenLinks = opts.FailOnBrokenLinks
+	repoB.failOnBrokenLinks = opts.FailOnBrokenLinks
+	repoA.strict = opts.Strict
+	repoB.strict = opts.Strict
+	repoA.renderConcurrency = opts.RenderConcurrency
+	repoB.renderConcurrency = opts.RenderConcurrency
+	repoA.exclude = parseIgnore([]byte(strings.Join(opts.Exclude, "\n")))
+	repoB.exclude = repoA.exclude
+	repoA.include = parseIgnore([]byte(strings.Join(opts.Include, "\n")))
+	repoB.include = repoA.include
+
+	s.active.Store(repoA)
+	s.versionA = repoA
+	s.versionB = repoB
+	return s, nil
+}
+
+// newFileProvider returns where the repo's files come from: the provider in
+// opts, or a client for the host providerName names. name identifies the
+// repo in the disk cache.
+func newFileProvider(logger *log.Logger, opts Options) (fp FileProvider, name string, err error) {
+	if opts.UseCache && (opts.Provider != nil || (opts.ProviderName != "" && opts.ProviderName != "github")) {
+		return nil, "", errors.New("the cache only works with GitHub repos")
+	}
+
+	switch {
+	case opts.Provider != nil:
+		name = slugify(opts.RepoURL)
+		if name == "" {
+			name = "repo"
+		}
+		return opts.Provider, name, nil
+
+	case opts.ProviderName == "gitlab":
+		glclient, err := newGitLabClient(logger, opts.RepoURL, opts.GitLabToken)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create gitlab client: %w", err)
+		}
+		glclient.maxZipSize = opts.MaxZipSize
+		glclient.downloadRetries = opts.DownloadRetries
+		return glclient, "gitlab-" + strings.ReplaceAll(glclient.project, "/", "-"), nil
+
+	case opts.ProviderName == "git":
+		gclient, err := newGitClient(logger, opts.RepoURL, opts.GitDir, opts.GitToken, opts.GitSSHKey)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create git client: %w", err)
+		}
+		gclient.depth = opts.GitDepth
+		return gclient, "git-" + gitRepoName(opts.RepoURL), nil
+
+	case opts.ProviderName != "" && opts.ProviderName != "github":
+		return nil, "", fmt.Errorf("unknown provider %q, use github, gitlab or git", opts.ProviderName)
+	}
+
+	ghclient, err := newGitHubClient(logger, githubAPI, opts.RepoURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create github client: %w", err)
+	}
+	ghclient.maxZipSize = opts.MaxZipSize
+	ghclient.downloadRetries = opts.DownloadRetries
 	fp = ghclient
 
-	if useCache {
+	if opts.UseCache {
 		logger.Println("using cached github client")
 		cachedClient, err := newCachedGitHubClient(logger, ghclient)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create cached github client: %w", err)
+			return nil, "", fmt.Errorf("failed to create cached github client: %w", err)
 		}
 		fp = cachedClient
 	}
 
+	return fp, ghclient.owner + "-" + ghclient.name, nil
+}
+
+// cleanBasePath normalizes a base path to have a leading slash and no
+// trailing one. The root is the empty string.
+func cleanBasePath(p string) string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// loadSnippet returns the html in v, or read from the file v names when it
+// doesn't start with a tag.
+func loadSnippet(v string) (template.HTML, error) {
+	v = strings.TrimSpace(v)
+	if v == "" || strings.HasPrefix(v, "<") {
+		return template.HTML(v), nil
+	}
+
+	b, err := os.ReadFile(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to read snippet %s: %w", v, err)
+	}
+	return template.HTML(b), nil
+}
+
+// parseTemplate parses the built-in wrapper and, when path is set, replaces
+// it with the template in that file. Custom templates can still use the
+// built-in "nav", "style" and "print-style" templates.
+func parseTemplate(path string) (*template.Template, error) {
 	t, err := template.New("wrapper").Parse(wrapper)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse template: %w", err)
 	}
+	if _, err := t.New("style").Parse(defaultCSS); err != nil {
+		return nil, fmt.Errorf("failed to parse styles: %w", err)
+	}
+	if _, err := t.New("print-style").Parse(printCSS); err != nil {
+		return nil, fmt.Errorf("failed to parse print styles: %w", err)
+	}
+
+	if path == "" {
+		return t, nil
+	}
 
-	repoA := newRepo(fp)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template %s: %w", path, err)
+	}
 
-	return &site{
-		title:      siteTitle,
-		logger:     logger,
-		activeRepo: repoA,
-		versionA:   repoA,
-		versionB:   newRepo(fp),
-		tpl:        t,
-	}, nil
+	t, err = t.Parse(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", path, err)
+	}
+
+	return t, nil
 }
 
-func (s *site) Serve(ctx context.Context) error {
-	s.logger.Println("syncing active repo")
-	if err := s.activeRepo.Sync(ctx); err != nil {
-		return fmt.Errorf("failed to sync repo: %w", err)
+// sites are the sites with a repo of their own: the mounts when serving
+// several repos, or s itself.
+func (s *Site) sites() []*Site {
+	if len(s.mounts) > 0 {
+		return s.mounts
+	}
+	return []*Site{s}
+}
+
+// Sync fetches the repo of the site, or of each mount, retrying as often
+// as Options.StartupRetries allows, and readies the standby buffer. Serve
+// calls it before listening. A site served only through ServeHTTP must be
+// synced first, and is not kept in sync afterwards.
+func (s *Site) Sync(ctx context.Context) error {
+	for _, site := range s.sites() {
+		site.logger.Printf("syncing active repo for %s/\n", site.basePath)
+		if err := site.initialSync(ctx); err != nil {
+			return err
+		}
+		site.warmStandby(ctx)
+		site.pruneRenderCache()
+	}
+	return nil
+}
+
+// Serve syncs the site and serves it on :8080, or over HTTPS with
+// Options.AutocertDomains, until ctx is done, syncing again every five
+// minutes.
+func (s *Site) Serve(ctx context.Context) error {
+	sites := s.sites()
+	for _, site := range sites {
+		if site.contents != nil {
+			defer site.contents.Close()
+		}
+	}
+
+	if err := s.Sync(ctx); err != nil {
+		return err
 	}
 
 	g, ctx := errgroup.WithContext(ctx)
 
 	// Run syncRepos in a goroutine, but do not let its error stop Serve
-	g.Go(func() error {
-		err := s.syncRepos(ctx)
-		if err != nil {
-			s.logger.Printf("failed to sync repos: %v", err)
-		}
-		return nil // always return nil so Serve doesn't stop
-	})
+	for _, site := range sites {
+		g.Go(func() error {
+			err := site.syncRepos(ctx)
+			if err != nil {
+				site.logger.Printf("failed to sync repos: %v", err)
+			}
+			return nil // always return nil so Serve doesn't stop
+		})
+	}
+
+	if s.autocert != nil {
+		g.Go(func() error {
+			s.logger.Printf("starting https server on :443 for %s\n", strings.Join(s.autocertDomains, ", "))
+			server := &http.Server{
+				Addr:      ":443",
+				Handler:   s.handler(),
+				TLSConfig: s.autocert.TLSConfig(),
+			}
+			return s.listenAutocert(ctx, server)
+		})
+		g.Go(func() error {
+			s.logger.Println("starting http redirect server on :80")
+			return s.listenAutocert(ctx, &http.Server{
+				Addr:    ":80",
+				Handler: s.autocert.HTTPHandler(nil),
+			})
+		})
+		return g.Wait()
+	}
 
 	g.Go(func() error {
 		s.logger.Println("starting server on :8080")
 		server := &http.Server{
 			Addr:    ":8080",
-			Handler: s,
+			Handler: s.handler(),
 		}
 
-		shutdown := func() {
-			<-ctx.Done()
-			shutdownctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			if err := server.Shutdown(shutdownctx); err != nil {
-				s.logger.Printf("failed to shutdown server: %v", err)
-			}
-		}
-		go shutdown()
-
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		ln, err := net.Listen("tcp", server.Addr)
+		if err != nil {
 			return fmt.Errorf("server error: %w", err)
 		}
-		return nil
+		return s.serveListener(ctx, server, ln)
 	})
 
 	return g.Wait()
 }
 
-func (s *site) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+// listenAutocert binds server's address for automatic HTTPS and serves on
+// it. ACME challenges only work when both :80 and :443 are reachable.
+func (s *Site) listenAutocert(ctx context.Context, server *http.Server) error {
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s, autocert needs ports 80 and 443 open to the internet and permission to bind them: %w", server.Addr, err)
+	}
+	return s.serveListener(ctx, server, ln)
+}
+
+// serveListener serves on ln until ctx is done, using TLS when the site has
+// a certificate or server is configured with one.
+func (s *Site) serveListener(ctx context.Context, server *http.Server, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		s.shutdown(server)
+	}()
+
+	var err error
+	if s.tlsCert != "" || server.TLSConfig != nil {
+		s.logger.Println("serving https")
+		err = server.ServeTLS(ln, s.tlsCert, s.tlsKey)
+	} else {
+		err = server.Serve(ln)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("server error: %w", err)
+	}
+	return nil
+}
+
+// shutdown stops server, giving in-flight requests up to the configured
+// timeout to finish.
+func (s *Site) shutdown(server *http.Server) {
+	shutdownctx := context.Background()
+	if s.shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownctx, cancel = context.WithTimeout(shutdownctx, s.shutdownTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	if err := server.Shutdown(shutdownctx); err != nil {
+		s.logger.Printf("failed to shutdown server: %v\n", err)
+	}
+	s.logger.Printf("server shut down in %s\n", time.Since(start).Round(time.Millisecond))
+}
+
+// ServeHTTP serves the page r asks for. It leaves out the basic auth, rate
+// limits, CORS, security headers and access log Serve wraps it in.
+func (s *Site) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		if err := recover(); err != nil {
-			fmt.Println("recovered from panic:", err)
+			s.logger.Printf("error: panic serving %s: %v\n%s", r.URL.Path, err, debug.Stack())
 			http.Error(w, "internal server error", http.StatusInternalServerError)
 		}
 	}()
 
-	if r.URL.Path == "/" {
+	urlPath := r.URL.Path
+	if s.basePath != "" {
+		if urlPath == s.basePath {
+			http.Redirect(w, r, s.basePath+"/", http.StatusMovedPermanently)
+			return
+		}
+		rest, ok := strings.CutPrefix(urlPath, s.basePath)
+		if !ok || !strings.HasPrefix(rest, "/") {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		urlPath = rest
+	}
+
+	if len(s.mounts) > 0 {
+		s.serveMounts(w, r, urlPath)
+		return
+	}
+
+	switch urlPath {
+	case "/":
 		s.serveIndex(w, r)
 		return
+	case "/version":
+		s.serveVersion(w, r)
+		return
+	case "/healthz":
+		s.serveHealthz(w, r)
+		return
+	case "/archive":
+		s.serveArchive(w, r)
+		return
+	case "/linkcheck":
+		s.serveLinkcheck(w, r)
+		return
+	case "/status":
+		s.serveStatus(w, r)
+		return
+	case "/api/index":
+		s.serveMetadata(w, r)
+		return
+	case "/feed.atom":
+		s.serveAtom(w, r)
+		return
+	case "/llms.txt":
+		s.serveLLMs(w, r)
+		return
+	case adminSyncPath:
+		s.serveAdminSync(w, r)
+		return
+	case "/tags":
+		s.serveTags(w, r)
+		return
+	case "/robots.txt":
+		s.serveRobots(w, r)
+		return
+	case "/favicon.ico":
+		s.serveFavicon(w, r)
+		return
+	}
+
+	if rawPath, ok := strings.CutPrefix(urlPath, "/raw/"); ok {
+		s.serveRaw(w, rawPath)
+		return
+	}
+	if docPath, ok := strings.CutPrefix(urlPath, "/api/docs/"); ok {
+		if docPath, ok := strings.CutSuffix(docPath, "/stats"); ok {
+			s.serveStats(w, docPath)
+			return
+		}
+	}
+	if slug, ok := strings.CutPrefix(urlPath, "/tags/"); ok {
+		s.serveTag(w, r, slug)
+		return
+	}
+
+	p, ok := cleanPath(urlPath)
+	if !ok {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
 	}
 
-	path := strings.TrimPrefix(r.URL.Path, "/")
-	doc, ok := s.activeRepo.Document(path)
+	doc, ok := s.activeRepo().Document(p)
 	if !ok {
+		if target, ok := s.activeRepo().Redirect(p); ok {
+			http.Redirect(w, r, target.URL(), http.StatusMovedPermanently)
+			return
+		}
+		if s.serveListing(w, r, p) {
+			return
+		}
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
 
+	// Send mis-cased paths to the canonical one so there is only one URL
+	// for each document.
+	if doc.servedPath() != p {
+		target := doc.URL()
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+
+	// Landing pages need the trailing slash so their relative links
+	// resolve inside the folder.
+	if doc.isLandingPage() && !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+
 	s.serve(w, r, doc)
 }
 
-func (s *site) serve(w http.ResponseWriter, r *http.Request, doc *document) {
-	b, err := s.renderDocument(doc)
+// serveRaw writes the markdown source of the document at p, relative to
+// /raw/.
+func (s *Site) serveRaw(w http.ResponseWriter, p string) {
+	p, ok := cleanPath("/" + p)
+	if !ok {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	doc := s.activeRepo().Index()
+	if p != "." {
+		if doc, ok = s.activeRepo().Document(p); !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	src := doc.Source()
+	if !doc.opts.KeepComments {
+		src = stripSourceComments(src)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write(src)
+}
+
+// cleanPath turns a request path into a repo-relative lookup key. Paths that
+// still try to climb out of the root once cleaned are rejected.
+func cleanPath(requestPath string) (string, bool) {
+	p := path.Clean(strings.TrimPrefix(requestPath, "/"))
+	if p == ".." || strings.HasPrefix(p, "../") || strings.Contains(p, "/../") || strings.HasPrefix(p, "/") {
+		return "", false
+	}
+
+	return p, true
+}
+
+func (s *Site) serve(w http.ResponseWriter, r *http.Request, doc *document) {
+	etag := documentETag(s.pageFingerprint, s.activeRepo().Hash(), doc.path)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, no-cache")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		// A 304's headers replace the cached ones, and the cached page
+		// carries the nonce of the policy it was first served with.
+		w.Header().Del("Content-Security-Policy")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	b, err := s.renderDocument(doc, requestNonce(r))
 	if err != nil {
 		fmt.Println("failed to render document:", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -163,31 +940,284 @@ func (s *site) serve(w http.ResponseWriter, r *http.Request, doc *document) {
 	_, _ = w.Write(b)
 }
 
-func (s *site) serveIndex(w http.ResponseWriter, r *http.Request) {
-	s.serve(w, r, s.activeRepo.Index())
+// documentETag derives a strong validator from the site's page fingerprint,
+// the repo hash and the document path. Rendering is deterministic, so they
+// identify the bytes served.
+func documentETag(fingerprint, hash, path string) string {
+	sum := sha256.Sum256([]byte(fingerprint + "/" + hash + "/" + path))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// pageFingerprint identifies what goes into s's pages other than the repo:
+// the render settings and build, the template and styles, and the site
+// settings the template shows.
+func pageFingerprint(s *Site, opts Options) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %q %t %t %q %q %t %t %t\n",
+		opts.Render.fingerprint(), s.title, s.noNav, s.themeToggle, s.baseURL,
+		s.contentWidth, s.noReadingTime, opts.Minify, s.autoindex)
+	tpls := s.tpl.Templates()
+	slices.SortFunc(tpls, func(a, b *template.Template) int { return strings.Compare(a.Name(), b.Name()) })
+	for _, t := range tpls {
+		if t.Tree != nil {
+			fmt.Fprintf(h, "%s %s\n", t.Name(), t.Tree.Root)
+		}
+	}
+	fmt.Fprintf(h, "%s\n%s\n", s.css, s.analytics)
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Site) serveIndex(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, s.activeRepo().Index())
 }
 
-func (s *site) renderDocument(doc *document) ([]byte, error) {
-	contents, err := doc.Render()
+// renderDocument renders the page for doc, with nonce on its inline
+// scripts.
+func (s *Site) renderDocument(doc *document, nonce string) ([]byte, error) {
+	active := s.activeRepo()
+	contents, err := active.Render(doc)
 	if err != nil {
 		return nil, err
 	}
 
+	data := s.newPage(doc)
+	data.Body = template.HTML(contents)
+	data.Nonce = nonce
+	// The site's description stands in for documents without an excerpt,
+	// and for the index when it has one.
+	if excerpt := doc.Excerpt(); excerpt != "" && (data.Description == "" || doc != active.Index()) {
+		data.Description = excerpt
+	}
+	data.Mermaid = doc.UsesMermaid()
+	data.Math = doc.UsesMath()
+	data.CopyCode = doc.UsesCopyCode()
+	if doc != active.Index() || doc.title != "" {
+		data.PageTitle = doc.Title()
+	}
+	if s.baseURL != "" {
+		data.URL = s.baseURL + doc.URL()
+	}
+	if doc != active.Index() {
+		data.Breadcrumbs = s.breadcrumbs(doc)
+		if !s.noReadingTime {
+			data.ReadingTime = doc.ReadingTime()
+		}
+
+		for _, b := range active.Backlinks(doc.servedPath()) {
+			data.Backlinks = append(data.Backlinks, pageLink{Name: b.Title(), URL: b.URL()})
+		}
+
+		prev, next := active.Neighbors(doc.servedPath())
+		if prev != nil {
+			data.Prev = &pageLink{Name: prev.servedPath(), URL: prev.URL()}
+		}
+		if next != nil {
+			data.Next = &pageLink{Name: next.servedPath(), URL: next.URL()}
+		}
+	}
+
+	return s.renderPage(data)
+}
+
+// newPage fills in the parts of the page shared by every view. current is
+// the document being viewed, if any.
+func (s *Site) newPage(current *document) pageData {
+	data := pageData{
+		Title:        s.siteTitle(),
+		CSS:          s.css,
+		ContentWidth: s.contentWidth,
+		Analytics:    s.analytics,
+		Favicon:      s.basePath + "/favicon.ico",
+		PageTitle:    s.siteTitle(),
+		Description:  s.siteDescription(),
+		ThemeToggle:  s.themeToggle,
+	}
+	if data.ContentWidth == "" {
+		data.ContentWidth = DefaultContentWidth
+	}
+	if s.activeRepo() != nil {
+		data.Feed = s.basePath + "/feed.atom"
+	}
+	if !s.noNav && s.activeRepo() != nil {
+		data.Nav = buildNav(s.activeRepo().Documents(), current)
+	}
+	return data
+}
+
+func (s *Site) renderPage(data pageData) ([]byte, error) {
 	var buf bytes.Buffer
-	if err := s.tpl.Execute(&buf, struct {
-		Title string
-		Body  template.HTML
-	}{
-		Title: s.title,
-		Body:  template.HTML(contents),
-	}); err != nil {
+	if err := s.tpl.Execute(&buf, data); err != nil {
 		return nil, err
 	}
 
+	if s.minifier != nil {
+		return s.minifier.Bytes("text/html", buf.Bytes())
+	}
 	return buf.Bytes(), nil
 }
 
-func (s *site) syncRepos(ctx context.Context) error {
+// renderNamedPage renders a page with its body produced by the named
+// template.
+func (s *Site) renderNamedPage(data pageData, name string, body any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := s.tpl.ExecuteTemplate(&buf, name, body); err != nil {
+		return nil, err
+	}
+	data.Body = template.HTML(buf.String())
+
+	return s.renderPage(data)
+}
+
+// servePage writes a page that isn't backed by a single document, with its
+// body produced by the named template.
+func (s *Site) servePage(w http.ResponseWriter, r *http.Request, data pageData, name string, body any) {
+	data.Nonce = requestNonce(r)
+	b, err := s.renderNamedPage(data, name, body)
+	if err != nil {
+		s.logger.Printf("failed to render %s: %v\n", name, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
+
+func (s *Site) breadcrumbs(doc *document) []pageLink {
+	crumbs := []pageLink{{Name: s.siteTitle(), URL: s.basePath + "/"}}
+
+	segments := strings.Split(doc.servedPath(), "/")
+	for i, segment := range segments[:len(segments)-1] {
+		crumb := pageLink{Name: segment}
+		folder := strings.Join(segments[:i+1], "/")
+		if d, ok := s.activeRepo().Document(folder); ok && d.isLandingPage() {
+			crumb.URL = d.URL()
+		} else if s.autoindex {
+			crumb.URL = s.basePath + "/" + folder + "/"
+		}
+		crumbs = append(crumbs, crumb)
+	}
+
+	return append(crumbs, pageLink{Name: segments[len(segments)-1]})
+}
+
+// initialSync runs the first sync of the active repo, retrying with
+// exponential backoff so a transient GitHub error doesn't stop the server
+// before it starts.
+func (s *Site) initialSync(ctx context.Context) error {
+	delay := s.startupRetryDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := s.syncRepo(ctx, s.activeRepo())
+		s.syncs.record(err)
+		if err == nil {
+			return nil
+		}
+		if attempt > s.startupRetries {
+			return fmt.Errorf("failed to sync repo after %d attempts: %w", attempt, err)
+		}
+
+		s.logger.Printf("sync attempt %d failed, retrying in %s: %v\n", attempt, delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, time.Minute)
+	}
+}
+
+// warmStandby syncs the buffer that isn't active, so both hold the repo
+// from the start. A failure isn't fatal: the standby is synced again
+// before the first swap, which only happens once that succeeds.
+func (s *Site) warmStandby(ctx context.Context) {
+	standby := s.versionB
+	if s.activeRepo() == s.versionB {
+		standby = s.versionA
+	}
+	if standby == nil || standby == s.activeRepo() {
+		return
+	}
+
+	if err := standby.Sync(ctx); err != nil {
+		s.logger.Printf("failed to warm standby repo, will retry on the next sync: %v\n", err)
+	}
+}
+
+// activeRepo returns the buffer being served.
+func (s *Site) activeRepo() *repo {
+	return s.active.Load()
+}
+
+// swapRepos syncs the standby buffer and makes it the active one. The
+// active buffer keeps serving when the sync fails.
+// syncRepo syncs r, downloading again at once when the archive came back
+// corrupt, which is usually a download cut short. Other failures, such as
+// a missing repo or broken content, wait for the next attempt.
+func (s *Site) syncRepo(ctx context.Context, r *repo) error {
+	err := r.Sync(ctx)
+	if errors.Is(err, errCorruptArchive) {
+		s.logger.Printf("archive was corrupt, downloading it again: %v\n", err)
+		err = r.Sync(ctx)
+	}
+	return err
+}
+
+func (s *Site) swapRepos(ctx context.Context) error {
+	s.swapMu.Lock()
+	defer s.swapMu.Unlock()
+
+	standby, name := s.versionB, "B"
+	if s.activeRepo() == s.versionB {
+		standby, name = s.versionA, "A"
+	}
+	if err := s.syncRepo(ctx, standby); err != nil {
+		err = fmt.Errorf("failed to sync repo %s: %w", name, err)
+		s.syncs.record(err)
+		return err
+	}
+	s.syncs.record(nil)
+	s.active.Store(standby)
+	s.pruneRenderCache()
+	return nil
+}
+
+// pruneRenderCache removes the disk renderings of versions neither buffer
+// holds. It only runs once a swap is done, so the renderings of the
+// buffer being served are never removed from under it.
+func (s *Site) pruneRenderCache() {
+	if s.versionA == nil || s.versionA.opts.diskCache == nil {
+		return
+	}
+	hashes := []string{s.versionA.Hash()}
+	if s.versionB != nil {
+		hashes = append(hashes, s.versionB.Hash())
+	}
+	if err := s.versionA.opts.diskCache.Prune(s.versionA.opts, hashes...); err != nil {
+		s.logger.Printf("failed to prune render cache: %v\n", err)
+	}
+}
+
+func (s *Site) syncRepos(ctx context.Context) error {
 	ticker := time.NewTicker(5 * time.Minute)
 
 	for {
@@ -196,17 +1226,10 @@ func (s *site) syncRepos(ctx context.Context) error {
 			return nil
 
 		case <-ticker.C:
-			switch s.activeRepo {
-			case s.versionA:
-				if err := s.versionB.Sync(ctx); err != nil {
-					return fmt.Errorf("failed to sync repo B: %w", err)
-				}
-				s.activeRepo = s.versionB
-			case s.versionB:
-				if err := s.versionA.Sync(ctx); err != nil {
-					return fmt.Errorf("failed to sync repo A: %w", err)
-				}
-				s.activeRepo = s.versionA
+			// A failed sync keeps the current buffer serving and is
+			// retried on the next tick.
+			if err := s.swapRepos(ctx); err != nil {
+				s.logger.Printf("skipping sync, will retry: %v\n", err)
 			}
 		}
 	}
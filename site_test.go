@@ -0,0 +1,1055 @@
+package thoughts
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+type fakeProvider struct {
+	hash  string
+	files fstest.MapFS
+}
+
+func (f *fakeProvider) LastHash(ctx context.Context) (string, error) {
+	return f.hash, nil
+}
+
+func (f *fakeProvider) Contents(ctx context.Context) (fs.FS, func(), error) {
+	return f.files, func() {}, nil
+}
+
+// newTestFS wraps files in a top-level directory the way GitHub zipballs do.
+func newTestFS(files map[string]string) fstest.MapFS {
+	m := fstest.MapFS{}
+	for name, contents := range files {
+		m["owner-name-abc123/"+name] = &fstest.MapFile{Data: []byte(contents)}
+	}
+	return m
+}
+
+func mustParseWrapper(tb testing.TB) *template.Template {
+	tb.Helper()
+
+	tpl, err := parseTemplate("")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return tpl
+}
+
+func newTestSite(t *testing.T, files map[string]string) *Site {
+	t.Helper()
+	return newTestSiteWithOptions(t, files, RenderOptions{})
+}
+
+func newTestSiteWithOptions(t *testing.T, files map[string]string, opts RenderOptions) *Site {
+	t.Helper()
+
+	logger := log.New(io.Discard, "", 0)
+	r := newRepo(logger, &fakeProvider{hash: "abc123", files: newTestFS(files)}, opts)
+	if err := r.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Site{
+		title:    "test",
+		logger:   logger,
+		versionA: r,
+		versionB: r,
+		tpl:      mustParseWrapper(t),
+	}
+	s.active.Store(r)
+	return s
+}
+
+// get serves a GET request for path and returns the body.
+func get(t *testing.T, s *Site, path string) string {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec.Body.String()
+}
+
+func TestSiteServeETag(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md":       "# Hello",
+		"thoughts/foo.md": "# Foo",
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/thoughts/foo", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an ETag header")
+	}
+	if rec.Header().Get("Cache-Control") == "" {
+		t.Fatal("expected a Cache-Control header")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/thoughts/foo", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", rec.Code)
+	}
+	if rec.Body.Len() != 0 {
+		t.Fatalf("expected empty body, got %q", rec.Body.String())
+	}
+}
+
+func TestSiteServeETagChangesWithHash(t *testing.T) {
+	a := documentETag("site", "hash-a", "README.md")
+	b := documentETag("site", "hash-b", "README.md")
+	if a == b {
+		t.Fatal("expected etag to change when the repo hash changes")
+	}
+
+	if etagMatches(`"other"`, a) {
+		t.Fatal("expected mismatched etag not to match")
+	}
+	if !etagMatches(`"other", W/`+a, a) {
+		t.Fatal("expected etag list to match")
+	}
+}
+
+func TestSiteServeETagChangesWithOptions(t *testing.T) {
+	etag := func(opts Options) string {
+		t.Helper()
+		opts.RepoURL = "notes"
+		opts.Provider = &fakeProvider{hash: "abc123", files: newTestFS(map[string]string{"README.md": "# Hello"})}
+		s, err := newSite(log.New(io.Discard, "", 0), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.initialSync(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Header().Get("ETag")
+	}
+
+	base := etag(Options{})
+	if base == "" || etag(Options{}) != base {
+		t.Fatalf("expected a stable ETag for the same options, got %q", base)
+	}
+	for name, opts := range map[string]Options{
+		"render flag": {Render: RenderOptions{Emoji: true}},
+		"site title":  {SiteTitle: "Notes"},
+		"theme":       {ThemeToggle: true},
+	} {
+		if got := etag(opts); got == base {
+			t.Errorf("%s: expected the ETag to change, got %q both times", name, got)
+		}
+	}
+
+	defer func(v string) { version = v }(version)
+	version = "v-upgraded"
+	if got := etag(Options{}); got == base {
+		t.Errorf("expected an upgrade to change the ETag, got %q both times", got)
+	}
+}
+
+func TestSiteRejectsPathTraversal(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md": "# Hello",
+	})
+
+	for _, p := range []string{"/../../etc/passwd", "/..", "/thoughts/../../etc/passwd", "//etc/passwd/../.."} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path = p
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", p, rec.Code)
+		}
+	}
+}
+
+func TestSiteServesFolderLandingPages(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md":             "# Hello",
+		"thoughts/README.md":    "# Thoughts landing",
+		"thoughts/foo.md":       "# Foo",
+		"projects/go/README.md": "# Go projects",
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/thoughts/", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "Thoughts landing") {
+		t.Fatalf("expected landing page contents, got %s", rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/projects/go", nil)
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMovedPermanently {
+		t.Fatalf("expected 301, got %d", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/projects/go/" {
+		t.Fatalf("expected redirect to /projects/go/, got %q", loc)
+	}
+}
+
+func TestSiteBreadcrumbs(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md":            "# Hello",
+		"top.md":               "# Top",
+		"projects/README.md":   "# Projects",
+		"projects/go/notes.md": "# Notes",
+	})
+
+	doc, _ := s.activeRepo().Document("projects/go/notes")
+	got := s.breadcrumbs(doc)
+	want := []pageLink{
+		{Name: "test", URL: "/"},
+		{Name: "projects", URL: "/projects/"},
+		{Name: "go"},
+		{Name: "notes"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d crumbs, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("crumb %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+
+	doc, _ = s.activeRepo().Document("top")
+	if got := s.breadcrumbs(doc); len(got) != 2 || got[0].URL != "/" || got[1].Name != "top" {
+		t.Errorf("expected root and top crumbs, got %+v", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if strings.Contains(rec.Body.String(), `class="breadcrumbs"`) {
+		t.Errorf("expected no breadcrumbs on the index, got %s", rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/projects/go/notes", nil)
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), `<a href="/projects/">projects</a>`) {
+		t.Errorf("expected crumb link to the projects landing page, got %s", rec.Body.String())
+	}
+}
+
+func TestSiteCustomTemplate(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md":       "# Hello",
+		"thoughts/foo.md": "# Foo",
+	})
+
+	path := filepath.Join(t.TempDir(), "custom.html")
+	custom := `<html><body class="custom"><h6>{{.Title}}</h6>{{.Body}}{{template "nav" .Nav}}</body></html>`
+	if err := os.WriteFile(path, []byte(custom), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tpl, err := parseTemplate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.tpl = tpl
+
+	req := httptest.NewRequest(http.MethodGet, "/thoughts/foo", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	for _, want := range []string{`<body class="custom">`, `<h6>test</h6>`, `<h1 id="foo">Foo`, `href="/thoughts/foo"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in output, got %s", want, body)
+		}
+	}
+	if strings.Contains(body, "box-shadow") {
+		t.Errorf("expected built-in template not to be used, got %s", body)
+	}
+
+	if err := os.WriteFile(path, []byte("{{.Title"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTemplate(path); err == nil {
+		t.Fatal("expected a parse error for a broken template")
+	}
+}
+
+func TestSiteCustomCSS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "custom.css")
+	if err := os.WriteFile(path, []byte("body { font-family: serif; color: #123456; }"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := newSite(log.New(io.Discard, "", 0), Options{RepoURL: "https://github.com/owner/name", CSSPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestSite(t, map[string]string{
+		"README.md": "# Hello",
+	})
+	s.css = created.css
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if !strings.Contains(body, "color: #123456;") {
+		t.Errorf("expected custom css in output, got %s", body)
+	}
+	if strings.Contains(body, "font-family: monospace") {
+		t.Errorf("expected custom css to replace the defaults, got %s", body)
+	}
+}
+
+func TestSiteEmbeddedTemplate(t *testing.T) {
+	created, err := newSite(log.New(io.Discard, "", 0), Options{RepoURL: "https://github.com/owner/name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestSite(t, map[string]string{
+		"README.md": "# Hello",
+	})
+	s.tpl = created.tpl
+
+	body := get(t, s, "/")
+	for _, want := range []string{"<!DOCTYPE html>", "<title>test</title>", `<h1 id="hello">Hello`, "font-family: monospace;", "width: 800px;", "@media print"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in output, got %s", want, body)
+		}
+	}
+
+	for _, name := range []string{"wrapper", "nav", "style", "print-style"} {
+		if created.tpl.Lookup(name) == nil {
+			t.Errorf("expected the built-in %q template", name)
+		}
+	}
+}
+
+func TestSiteThemeToggle(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md": "# Hello",
+	})
+
+	render := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	if body := render(); strings.Contains(body, "theme-toggle") {
+		t.Errorf("expected no theme toggle by default, got %s", body)
+	}
+
+	s.themeToggle = true
+	body := render()
+	for _, want := range []string{`class="theme-toggle"`, "prefers-color-scheme: dark", `localStorage.setItem("theme", next)`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in output, got %s", want, body)
+		}
+	}
+}
+
+func TestSiteOpenGraphTags(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md":       "# Hello",
+		"thoughts/foo.md": "# Foo note\n\nThis is what the note is about.\n",
+	})
+	s.baseURL = "https://example.com"
+
+	req := httptest.NewRequest(http.MethodGet, "/thoughts/foo", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`<meta property="og:title" content="Foo note">`,
+		`<meta name="description" content="This is what the note is about.">`,
+		`<meta property="og:description" content="This is what the note is about.">`,
+		`<meta property="og:url" content="https://example.com/thoughts/foo">`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in output, got %s", want, body)
+		}
+	}
+}
+
+func TestSiteShutdownWaitsForInFlightRequests(t *testing.T) {
+	for _, timeout := range []time.Duration{0, time.Second} {
+		started := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			time.Sleep(100 * time.Millisecond)
+			io.WriteString(w, "done")
+		}))
+
+		errc := make(chan error, 1)
+		go func() {
+			resp, err := http.Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			errc <- err
+		}()
+		<-started
+
+		s := &Site{logger: log.New(io.Discard, "", 0), shutdownTimeout: timeout}
+		s.shutdown(srv.Config)
+
+		if err := <-errc; err != nil {
+			t.Errorf("timeout %s: expected in-flight request to finish, got %v", timeout, err)
+		}
+		srv.Close()
+	}
+}
+
+func TestSiteBasePath(t *testing.T) {
+	s := newTestSiteWithOptions(t, map[string]string{
+		"README.md":       "# Hello",
+		"thoughts/foo.md": "# Foo",
+	}, RenderOptions{basePath: "/docs"})
+	s.basePath = "/docs"
+
+	body := get(t, s, "/docs/thoughts/foo")
+	if !strings.Contains(body, `<h1 id="foo">Foo`) {
+		t.Errorf("expected document at /docs/thoughts/foo, got %s", body)
+	}
+	for _, want := range []string{`href="/docs/thoughts/foo"`, `href="/docs/"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in %s", want, body)
+		}
+	}
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/docs/", http.StatusOK},
+		{"/docs", http.StatusMovedPermanently},
+		{"/thoughts/foo", http.StatusNotFound},
+		{"/docsthoughts/foo", http.StatusNotFound},
+		{"/", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.path, tt.code, rec.Code)
+		}
+	}
+}
+
+func TestCleanBasePath(t *testing.T) {
+	for in, want := range map[string]string{"": "", "/": "", "docs": "/docs", "/docs/": "/docs", "/a//b/": "/a/b"} {
+		if got := cleanBasePath(in); got != want {
+			t.Errorf("cleanBasePath(%q) = %q, expected %q", in, got, want)
+		}
+	}
+}
+
+// writeSelfSignedCert writes a certificate for 127.0.0.1 and its key to dir.
+func writeSelfSignedCert(t *testing.T, dir string) (certPath, keyPath string, cert *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "thoughts test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath = filepath.Join(dir, "cert.pem")
+	keyPath = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath, cert
+}
+
+func TestSiteServeTLS(t *testing.T) {
+	s := newTestSite(t, map[string]string{"README.md": "# Hello"})
+	var cert *x509.Certificate
+	s.tlsCert, s.tlsKey, cert = writeSelfSignedCert(t, t.TempDir())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.serveListener(ctx, &http.Server{Handler: s}, ln)
+	}()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+
+	resp, err := client.Get("https://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.TLS == nil || !strings.Contains(string(body), "Hello") {
+		t.Errorf("expected the index over tls, got %s", body)
+	}
+
+	cancel()
+	if err := <-errc; err != nil {
+		t.Errorf("expected clean shutdown, got %v", err)
+	}
+}
+
+func TestNewSiteRejectsConflictingTLSOptions(t *testing.T) {
+	tests := []struct {
+		opts Options
+		err  string
+	}{
+		{Options{TLSCert: "cert.pem"}, "tls cert and key must be set together"},
+		{Options{TLSKey: "key.pem"}, "tls cert and key must be set together"},
+		{Options{TLSCert: "cert.pem", TLSKey: "key.pem", AutocertDomains: []string{"example.com"}}, "autocert and a tls cert"},
+	}
+
+	for _, tt := range tests {
+		tt.opts.RepoURL = "josebalius/thoughts"
+		_, err := newSite(log.New(io.Discard, "", 0), tt.opts)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", tt.opts, tt.err, err)
+		}
+	}
+}
+
+func TestSiteRecoversFromPanics(t *testing.T) {
+	var buf bytes.Buffer
+	s := &Site{logger: log.New(&buf, "", 0)} // no repo, so any lookup panics
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/boom", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", rec.Code)
+	}
+	for _, want := range []string{"error: panic serving /boom", "goroutine ", "site.go"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in the log, got %s", want, buf.String())
+		}
+	}
+}
+
+func TestSiteReadingTime(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md": "# Hello",
+		"long.md":   strings.Repeat("word ", 1000),
+	})
+
+	if body := get(t, s, "/long"); !strings.Contains(body, "~5 min read") {
+		t.Errorf("expected a reading time on a note, got %s", body)
+	}
+	if body := get(t, s, "/"); strings.Contains(body, "min read") {
+		t.Errorf("expected no reading time on the index, got %s", body)
+	}
+
+	s.noReadingTime = true
+	if body := get(t, s, "/long"); strings.Contains(body, "min read") {
+		t.Errorf("expected no reading time when disabled, got %s", body)
+	}
+}
+
+func TestSiteServeRaw(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md":       "# Hello",
+		"thoughts/foo.md": "---\nweight: 1\n---\n# Foo\n\n[b](./b.md)",
+	})
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/raw/thoughts/foo", http.StatusOK, "---\nweight: 1\n---\n# Foo\n\n[b](./b.md)"},
+		{"/raw/", http.StatusOK, "# Hello"},
+		{"/raw/missing", http.StatusNotFound, ""},
+		{"/raw/../../etc/passwd", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.path, tt.code, rec.Code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		if got := rec.Body.String(); got != tt.body {
+			t.Errorf("%s: expected %q, got %q", tt.path, tt.body, got)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+			t.Errorf("%s: expected plain text, got %s", tt.path, ct)
+		}
+	}
+}
+
+func TestSiteServeRobots(t *testing.T) {
+	s := newTestSite(t, map[string]string{"README.md": "# Hello"})
+
+	tests := []struct {
+		mode string
+		body string
+	}{
+		{"", "User-agent: *\nAllow: /\n"},
+		{"allow", "User-agent: *\nAllow: /\n"},
+		{"disallow", "User-agent: *\nDisallow: /\n"},
+	}
+	for _, tt := range tests {
+		s.robots = tt.mode
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%q: expected 200, got %d", tt.mode, rec.Code)
+			continue
+		}
+		if got := rec.Body.String(); got != tt.body {
+			t.Errorf("%q: expected %q, got %q", tt.mode, tt.body, got)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+			t.Errorf("%q: expected plain text, got %s", tt.mode, ct)
+		}
+	}
+}
+
+func TestNewSiteRejectsUnknownRobotsMode(t *testing.T) {
+	_, err := newSite(log.New(io.Discard, "", 0), Options{RepoURL: "owner/name", Robots: "sometimes"})
+	if err == nil || !strings.Contains(err.Error(), "unknown robots mode") {
+		t.Fatalf("expected an unknown robots mode error, got %v", err)
+	}
+}
+
+func TestSiteServeFavicon(t *testing.T) {
+	s := newTestSite(t, map[string]string{"README.md": "# Hello"})
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/favicon.ico", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/x-icon" {
+		t.Errorf("expected image/x-icon, got %s", ct)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), defaultFavicon) {
+		t.Error("expected the built-in favicon")
+	}
+
+	if body := get(t, s, "/"); !strings.Contains(body, `<link rel="icon" href="/favicon.ico">`) {
+		t.Errorf("expected the favicon in the head, got %s", body)
+	}
+
+	iconPath := filepath.Join(t.TempDir(), "icon.png")
+	if err := os.WriteFile(iconPath, []byte("png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s.favicon, _ = loadFavicon(iconPath)
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/favicon.ico", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("expected image/png, got %s", ct)
+	}
+	if got := rec.Body.String(); got != "png" {
+		t.Errorf("expected the configured favicon, got %q", got)
+	}
+}
+
+// flakyProvider fails the first failures calls to LastHash.
+type flakyProvider struct {
+	fakeProvider
+	failures int
+	calls    int
+}
+
+func (f *flakyProvider) LastHash(ctx context.Context) (string, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return "", errors.New("github is down")
+	}
+	return f.hash, nil
+}
+
+// corruptProvider serves a corrupt archive for the first corrupt calls to
+// Contents.
+type corruptProvider struct {
+	fakeProvider
+	corrupt int
+	calls   int
+}
+
+func (c *corruptProvider) Contents(ctx context.Context) (fs.FS, func(), error) {
+	c.calls++
+	if c.calls <= c.corrupt {
+		return nil, nil, fmt.Errorf("failed to open zip reader: %w", errCorruptArchive)
+	}
+	return c.fakeProvider.Contents(ctx)
+}
+
+func TestSiteRetriesCorruptArchive(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	fp := &corruptProvider{fakeProvider: fakeProvider{hash: "abc123", files: newTestFS(map[string]string{"README.md": "# Hello"})}, corrupt: 1}
+	a, b := newRepo(logger, fp, RenderOptions{}), newRepo(logger, fp, RenderOptions{})
+	s := &Site{title: "test", logger: logger, tpl: mustParseWrapper(t), versionA: a, versionB: b}
+	s.active.Store(a)
+
+	if err := s.swapRepos(context.Background()); err != nil {
+		t.Fatalf("expected a corrupt archive to be downloaded again, got %v", err)
+	}
+	if fp.calls != 2 {
+		t.Errorf("expected 2 downloads, got %d", fp.calls)
+	}
+
+	// Any other failure waits for the next tick.
+	flaky := &flakyProvider{fakeProvider: fp.fakeProvider, failures: 1}
+	s.versionA = newRepo(logger, flaky, RenderOptions{})
+	if err := s.swapRepos(context.Background()); err == nil {
+		t.Fatal("expected the sync to fail")
+	}
+	if flaky.calls != 1 {
+		t.Errorf("expected one attempt, got %d", flaky.calls)
+	}
+}
+
+func TestSiteInitialSyncRetries(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries int
+		wantErr bool
+	}{
+		{"succeeds after retries", 3, false},
+		{"gives up", 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := log.New(io.Discard, "", 0)
+			fp := &flakyProvider{
+				fakeProvider: fakeProvider{hash: "abc123", files: newTestFS(map[string]string{"README.md": "# Hello"})},
+				failures:     2,
+			}
+			s := &Site{
+				logger:            logger,
+				startupRetries:    tt.retries,
+				startupRetryDelay: time.Millisecond,
+			}
+			s.active.Store(newRepo(logger, fp, RenderOptions{}))
+
+			err := s.initialSync(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error after running out of retries")
+				}
+				if fp.calls != tt.retries+1 {
+					t.Errorf("expected %d attempts, got %d", tt.retries+1, fp.calls)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fp.calls != 3 {
+				t.Errorf("expected 3 attempts, got %d", fp.calls)
+			}
+			if s.activeRepo().Index() == nil {
+				t.Error("expected the repo to be synced")
+			}
+		})
+	}
+}
+
+func TestSiteStatus(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md":       "# Hello",
+		"thoughts/foo.md": "# Foo",
+		"thoughts/bar.md": "# Bar",
+	})
+
+	body := get(t, s, "/status")
+	for _, want := range []string{"<code>abc123</code>", "<td>3</td>", "<td>A</td>", "<title>test</title>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in the status page, got %s", want, body)
+		}
+	}
+	if strings.Contains(body, "never") {
+		t.Errorf("expected a last sync time, got %s", body)
+	}
+}
+
+func TestSitePrintStyles(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md":       "# Hello",
+		"thoughts/foo.md": "# Foo",
+	})
+
+	body := get(t, s, "/thoughts/foo")
+	for _, want := range []string{"@media print", `<nav class="nav">`, `<div class="breadcrumbs">`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in output, got %s", want, body)
+		}
+	}
+
+	s.css = "body { color: red; }"
+	if body := get(t, s, "/thoughts/foo"); strings.Contains(body, "@media print") {
+		t.Errorf("expected custom css to replace the print styles, got %s", body)
+	}
+}
+
+func TestSiteContentWidth(t *testing.T) {
+	s := newTestSite(t, map[string]string{"README.md": "# Hello"})
+
+	if body := get(t, s, "/"); !strings.Contains(body, "width: 800px;") {
+		t.Errorf("expected the default width, got %s", body)
+	}
+
+	s.contentWidth = "90%"
+	if body := get(t, s, "/"); !strings.Contains(body, "width: 90%;") {
+		t.Errorf("expected the configured width, got %s", body)
+	}
+
+	for _, width := range []string{"wide", "800", "1px; color: red", "-5px"} {
+		_, err := newSite(log.New(io.Discard, "", 0), Options{RepoURL: "owner/name", ContentWidth: width})
+		if err == nil || !strings.Contains(err.Error(), "invalid content width") {
+			t.Errorf("%q: expected an invalid content width error, got %v", width, err)
+		}
+	}
+}
+
+func TestSiteAnalyticsSnippet(t *testing.T) {
+	s := newTestSite(t, map[string]string{"README.md": "# Hello"})
+	snippet := `<script defer data-domain="example.com" src="https://plausible.io/js/script.js"></script>`
+
+	if body := get(t, s, "/"); strings.Contains(body, "plausible") {
+		t.Errorf("expected no snippet by default, got %s", body)
+	}
+
+	path := filepath.Join(t.TempDir(), "analytics.html")
+	if err := os.WriteFile(path, []byte(snippet+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{snippet, path} {
+		analytics, err := loadSnippet(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.analytics = analytics
+
+		body := get(t, s, "/")
+		i := strings.Index(body, snippet)
+		if i < 0 || i > strings.Index(body, "</body>") {
+			t.Errorf("%s: expected the snippet before </body>, got %s", v, body)
+		}
+	}
+
+	if _, err := loadSnippet(filepath.Join(t.TempDir(), "missing.html")); err == nil {
+		t.Error("expected an error for a missing snippet file")
+	}
+}
+
+func TestSiteServesEmptyRepo(t *testing.T) {
+	s := newTestSite(t, map[string]string{})
+	if body := get(t, s, "/"); !strings.Contains(body, "No content yet") {
+		t.Errorf("expected the placeholder index, got %q", body)
+	}
+}
+
+func TestSiteServeCaseInsensitive(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md":       "# Index",
+		"thoughts/foo.md": "# Foo",
+		"Projects/Bar.md": "# Bar",
+		"notes/README.md": "# Notes",
+	})
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/thoughts/foo", http.StatusOK, ""},
+		{"/Projects/Bar", http.StatusOK, ""},
+		{"/Thoughts/Foo", http.StatusMovedPermanently, "/thoughts/foo"},
+		{"/THOUGHTS/FOO?x=1", http.StatusMovedPermanently, "/thoughts/foo?x=1"},
+		{"/projects/bar", http.StatusMovedPermanently, "/Projects/Bar"},
+		{"/Notes/", http.StatusMovedPermanently, "/notes/"},
+		{"/thoughts/missing", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, rec.Code)
+			}
+			if got := rec.Header().Get("Location"); got != tt.location {
+				t.Errorf("expected location %q, got %q", tt.location, got)
+			}
+		})
+	}
+}
+
+func TestSiteWarmStandby(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	fp := &fakeProvider{hash: "abc123", files: newTestFS(map[string]string{"README.md": "# Hello"})}
+	a, b := newRepo(logger, fp, RenderOptions{}), newRepo(logger, fp, RenderOptions{})
+	s := &Site{logger: logger, versionA: a, versionB: b, startupRetryDelay: time.Millisecond}
+	s.active.Store(a)
+
+	if err := s.initialSync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.warmStandby(context.Background())
+
+	for name, r := range map[string]*repo{"A": s.versionA, "B": s.versionB} {
+		if r.Index() == nil || r.Hash() != "abc123" {
+			t.Errorf("expected buffer %s to be synced at startup", name)
+		}
+	}
+}
+
+func TestSiteRecordsSyncHistory(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	fp := &flakyProvider{fakeProvider: fakeProvider{hash: "abc123", files: newTestFS(map[string]string{"README.md": "# Hello"})}}
+	a, b := newRepo(logger, fp, RenderOptions{}), newRepo(logger, fp, RenderOptions{})
+	if err := a.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s := &Site{title: "test", logger: logger, tpl: mustParseWrapper(t), versionA: a, versionB: b}
+	s.active.Store(a)
+
+	fp.failures = fp.calls + 2
+	for i := 0; i < 2; i++ {
+		if err := s.swapRepos(context.Background()); err == nil {
+			t.Fatal("expected the sync to fail")
+		}
+	}
+	h := s.syncs.snapshot()
+	if h.Failures != 2 || h.FailureStreak != 2 || !strings.Contains(h.LastError, "github is down") || h.LastErrorAt.IsZero() {
+		t.Errorf("unexpected history after failures: %+v", h)
+	}
+	if !h.LastSuccess.IsZero() {
+		t.Errorf("expected no success yet, got %v", h.LastSuccess)
+	}
+	if body := get(t, s, "/status"); !strings.Contains(body, "2, the last 2 in a row") || !strings.Contains(body, "github is down") {
+		t.Errorf("expected the failures on the status page, got %s", body)
+	}
+
+	if err := s.swapRepos(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	h = s.syncs.snapshot()
+	if h.Failures != 2 || h.FailureStreak != 0 || h.LastSuccess.IsZero() {
+		t.Errorf("unexpected history after a success: %+v", h)
+	}
+	if s.activeRepo() != b {
+		t.Error("expected the successful sync to swap buffers")
+	}
+}
+
+func TestSiteInjectedProvider(t *testing.T) {
+	fp := &fakeProvider{hash: "abc123", files: fstest.MapFS{
+		"README.md":  {Data: []byte("# Home\n\nSee [a](notes/a.md).")},
+		"notes/a.md": {Data: []byte("# Note A")},
+	}}
+	s, err := newSite(log.New(io.Discard, "", 0), Options{SiteTitle: "injected", Provider: fp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.initialSync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if body := get(t, s, "/"); !strings.Contains(body, "<title>injected</title>") || !strings.Contains(body, `href="/notes/a"`) {
+		t.Errorf("expected the index from the injected provider, got %s", body)
+	}
+	if body := get(t, s, "/notes/a"); !strings.Contains(body, "Note A") {
+		t.Errorf("expected the note from the injected provider, got %s", body)
+	}
+
+	fp.hash = "def456"
+	fp.files = fstest.MapFS{"README.md": {Data: []byte("# Updated")}}
+	if err := s.swapRepos(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if body := get(t, s, "/"); !strings.Contains(body, "Updated") {
+		t.Errorf("expected a sync to pick up the provider's new contents, got %s", body)
+	}
+
+	_, err = newSite(log.New(io.Discard, "", 0), Options{Provider: fp, UseCache: true})
+	if err == nil || !strings.Contains(err.Error(), "only works with GitHub") {
+		t.Errorf("expected the GitHub cache to be refused for an injected provider, got %v", err)
+	}
+}
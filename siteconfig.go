@@ -0,0 +1,76 @@
+package thoughts
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"gopkg.in/yaml.v3"
+)
+
+// siteConfigFiles are read from the repo root, first one found wins, for
+// site metadata kept with the content.
+var siteConfigFiles = []string{"_config.yml", "_config.yaml"}
+
+// siteConfig names and describes the site from the repo, overriding
+// -site-title. Empty fields fall back to the index's frontmatter, then to
+// the flags.
+type siteConfig struct {
+	Title       string `yaml:"title"`
+	Description string `yaml:"description"`
+}
+
+// or fills the fields c leaves empty from other.
+func (c siteConfig) or(other siteConfig) siteConfig {
+	if c.Title == "" {
+		c.Title = other.Title
+	}
+	if c.Description == "" {
+		c.Description = other.Description
+	}
+	return c
+}
+
+// readSiteConfig reads the first of siteConfigFiles the repo has. A
+// malformed file gives an empty config along with the error.
+func readSiteConfig(repo fs.FS) (siteConfig, error) {
+	for _, name := range siteConfigFiles {
+		b, err := fs.ReadFile(repo, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return siteConfig{}, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		var config siteConfig
+		if err := yaml.Unmarshal(b, &config); err != nil {
+			return siteConfig{}, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		return config, nil
+	}
+	return siteConfig{}, nil
+}
+
+// SiteConfig returns the site metadata found by the last sync.
+func (r *repo) SiteConfig() siteConfig {
+	return r.config
+}
+
+// siteTitle is the title from the repo, or -site-title when it has none.
+func (s *Site) siteTitle() string {
+	if s.activeRepo() != nil {
+		if title := s.activeRepo().SiteConfig().Title; title != "" {
+			return title
+		}
+	}
+	return s.title
+}
+
+// siteDescription is the description from the repo, if it has one.
+func (s *Site) siteDescription() string {
+	if s.activeRepo() == nil {
+		return ""
+	}
+	return s.activeRepo().SiteConfig().Description
+}
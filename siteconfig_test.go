@@ -0,0 +1,86 @@
+package thoughts
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestSiteConfigFromIndexFrontmatter(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md": "---\ntitle: Field Notes\ndescription: Things I learned the hard way.\n---\n# Hello\n\nWelcome in.\n",
+		"about.md":  "# About",
+	})
+
+	body := get(t, s, "/")
+	for _, want := range []string{
+		"<title>Field Notes</title>",
+		`<meta name="description" content="Things I learned the hard way.">`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q on the index, got %s", want, body)
+		}
+	}
+
+	body = get(t, s, "/about")
+	if !strings.Contains(body, "<title>Field Notes</title>") || !strings.Contains(body, `<a href="/">Field Notes</a>`) {
+		t.Errorf("expected the repo's title on every page, got %s", body)
+	}
+	if !strings.Contains(body, `content="Things I learned the hard way."`) {
+		t.Errorf("expected the site description for a document without an excerpt, got %s", body)
+	}
+}
+
+func TestSiteConfigFile(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"_config.yml": "title: From Config\n",
+		"README.md":   "---\ntitle: From Frontmatter\ndescription: Described in the README.\n---\n# Hello\n",
+	})
+
+	config := s.activeRepo().SiteConfig()
+	if config.Title != "From Config" || config.Description != "Described in the README." {
+		t.Errorf("expected the config file to win and the frontmatter to fill the gaps, got %+v", config)
+	}
+	if got := s.siteTitle(); got != "From Config" {
+		t.Errorf("expected the config title, got %q", got)
+	}
+}
+
+func TestSiteConfigFallsBackToFlag(t *testing.T) {
+	s := newTestSite(t, map[string]string{"README.md": "# Hello"})
+
+	if got := s.siteTitle(); got != "test" {
+		t.Errorf("expected the -site-title fallback, got %q", got)
+	}
+	if body := get(t, s, "/"); !strings.Contains(body, "<title>test</title>") {
+		t.Errorf("expected the flag's title, got %s", body)
+	}
+}
+
+func TestSiteConfigMalformed(t *testing.T) {
+	files := map[string]string{
+		"_config.yml": "title: [unclosed\n",
+		"README.md":   "---\ntitle: From Frontmatter\n---\n# Hello\n",
+	}
+
+	var logs bytes.Buffer
+	r := newRepo(log.New(&logs, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{})
+	if err := r.Sync(context.Background()); err != nil {
+		t.Fatalf("expected a malformed config to only warn, got %v", err)
+	}
+	if got := r.SiteConfig().Title; got != "From Frontmatter" {
+		t.Errorf("expected the frontmatter title, got %q", got)
+	}
+	if !strings.Contains(logs.String(), "failed to parse _config.yml") {
+		t.Errorf("expected a warning, got %q", logs.String())
+	}
+
+	strict := newRepo(log.New(io.Discard, "", 0), &fakeProvider{hash: "abc123", files: newTestFS(files)}, RenderOptions{})
+	strict.strict = true
+	if err := strict.Sync(context.Background()); err == nil || !strings.Contains(err.Error(), "failed to parse _config.yml") {
+		t.Errorf("expected a malformed config to fail in strict mode, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package thoughts
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// documentStats are a document's writing statistics. Words and
+// characters count prose only: frontmatter is never read and code blocks
+// are counted separately.
+type documentStats struct {
+	Words          int    `json:"words"`
+	Characters     int    `json:"characters"`
+	CodeWords      int    `json:"code_words"`
+	CodeCharacters int    `json:"code_characters"`
+	Headings       int    `json:"headings"`
+	ReadingTime    string `json:"reading_time"`
+	ReadingMinutes int    `json:"reading_minutes"`
+}
+
+// Stats counts the words, characters and headings in the document.
+func (d *document) Stats() documentStats {
+	stats := documentStats{
+		Words:          d.words,
+		ReadingTime:    d.ReadingTime(),
+		ReadingMinutes: d.readingMinutes(),
+	}
+
+	ast.WalkFunc(d.parse(), func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+
+		switch n := node.(type) {
+		case *ast.Heading:
+			stats.Headings++
+		case *ast.Text:
+			stats.Characters += utf8.RuneCount(n.Literal)
+		case *ast.Code:
+			stats.Characters += utf8.RuneCount(n.Literal)
+		case *ast.CodeBlock:
+			stats.CodeWords += len(strings.Fields(string(n.Literal)))
+			stats.CodeCharacters += utf8.RuneCount(n.Literal)
+		}
+		return ast.GoToNext
+	})
+	return stats
+}
+
+// serveStats writes the stats of the document at p, relative to
+// /api/docs/.
+func (s *Site) serveStats(w http.ResponseWriter, p string) {
+	p, ok := cleanPath("/" + p)
+	if !ok {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var doc *document
+	if p == "README" {
+		doc = s.activeRepo().Index()
+	} else {
+		doc, _ = s.activeRepo().Document(p)
+	}
+	if doc == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(doc.Stats()); err != nil {
+		s.logger.Printf("failed to write stats for %s: %v\n", p, err)
+	}
+}
@@ -0,0 +1,60 @@
+package thoughts
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeStats(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md": "# Index",
+		"notes/go.md": "---\ntags: [go, lots of words in the frontmatter]\n---\n" +
+			"# Go notes\n\nShort and sweet.\n\n## Setup\n\n```sh\ngo build ./...\n```\n",
+	})
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/docs/notes/go/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected a JSON content type, got %q", ct)
+	}
+
+	var got documentStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := documentStats{
+		Words:          6,  // Go notes, Short and sweet., Setup
+		Characters:     29, // "Go notes" + "Short and sweet." + "Setup"
+		CodeWords:      3,
+		CodeCharacters: 15,
+		Headings:       2,
+		ReadingTime:    "< 1 min read",
+		ReadingMinutes: 0,
+	}
+	if got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestServeStatsNotFound(t *testing.T) {
+	s := newTestSite(t, map[string]string{"README.md": "# Index"})
+
+	for p, want := range map[string]int{
+		"/api/docs/missing/stats": http.StatusNotFound,
+		"/api/docs/../stats":      http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, p, nil))
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", p, want, rec.Code)
+		}
+	}
+	if body := get(t, s, "/api/docs/README/stats"); body == "" {
+		t.Error("expected stats for the index")
+	}
+}
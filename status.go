@@ -0,0 +1,87 @@
+package thoughts
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// statusInfo is what the status page shows operators.
+type statusInfo struct {
+	Hash      string
+	Documents int
+	SyncedAt  time.Time
+	Buffer    string
+
+	syncHistory
+}
+
+// syncHistory records how the site's syncs have gone, so stale content can
+// be explained without reading the logs.
+type syncHistory struct {
+	LastSuccess   time.Time
+	LastError     string
+	LastErrorAt   time.Time
+	Failures      int // failed syncs since the site started
+	FailureStreak int // failed syncs since the last success
+}
+
+// syncRecorder keeps the sync history safe to read while the sync loop
+// updates it.
+type syncRecorder struct {
+	mu      sync.Mutex
+	history syncHistory
+}
+
+// record notes the outcome of a sync, err being nil for a success.
+func (r *syncRecorder) record(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if err == nil {
+		r.history.LastSuccess = now
+		r.history.FailureStreak = 0
+		return
+	}
+	r.history.LastError = err.Error()
+	r.history.LastErrorAt = now
+	r.history.Failures++
+	r.history.FailureStreak++
+}
+
+func (r *syncRecorder) snapshot() syncHistory {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.history
+}
+
+func (s *Site) status() statusInfo {
+	r := s.activeRepo()
+	info := statusInfo{
+		Hash:      r.Hash(),
+		Documents: len(r.Documents()),
+		SyncedAt:  r.SyncedAt(),
+		Buffer:    "A",
+
+		syncHistory: s.syncs.snapshot(),
+	}
+	if r.Index() != nil {
+		info.Documents++
+	}
+	if info.LastSuccess.After(info.SyncedAt) {
+		info.SyncedAt = info.LastSuccess
+	}
+	if r == s.versionB && r != s.versionA {
+		info.Buffer = "B"
+	}
+	return info
+}
+
+func (s *Site) serveStatus(w http.ResponseWriter, r *http.Request) {
+	data := s.newPage(nil)
+	data.PageTitle = "Status"
+	data.Breadcrumbs = []pageLink{{Name: s.siteTitle(), URL: s.basePath + "/"}, {Name: "status"}}
+
+	s.servePage(w, r, data, "status", s.status())
+}
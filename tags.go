@@ -0,0 +1,139 @@
+package thoughts
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// tagList is the frontmatter tags of a document, written either as a YAML
+// list or as a comma separated string.
+type tagList []string
+
+func (t *tagList) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		*t = splitList(value.Value)
+		return nil
+	case yaml.SequenceNode:
+		var tags []string
+		if err := value.Decode(&tags); err != nil {
+			return err
+		}
+		*t = tags
+		return nil
+	}
+	return fmt.Errorf("tags must be a list or a comma separated string")
+}
+
+// tag is a tag and the documents carrying it, ordered by path.
+type tag struct {
+	Name      string
+	Slug      string
+	URL       string
+	Documents []*document
+}
+
+// slugify lowercases s and joins its words with dashes for use in a URL.
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(s)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
+
+// buildTags groups documents by tag. Tags that differ only in case or
+// punctuation are the same tag, named after its first spelling by path.
+func buildTags(docs map[string]*document, basePath string) map[string]*tag {
+	sorted := make([]*document, 0, len(docs))
+	for _, d := range docs {
+		sorted = append(sorted, d)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].path < sorted[j].path
+	})
+
+	tags := make(map[string]*tag)
+	for _, d := range sorted {
+		seen := make(map[string]bool)
+		for _, name := range d.Tags() {
+			slug := slugify(name)
+			if slug == "" || seen[slug] {
+				continue
+			}
+			seen[slug] = true
+
+			t, ok := tags[slug]
+			if !ok {
+				t = &tag{Name: strings.TrimSpace(name), Slug: slug, URL: basePath + "/tags/" + slug}
+				tags[slug] = t
+			}
+			t.Documents = append(t.Documents, d)
+		}
+	}
+	return tags
+}
+
+// Tags returns every tag in the repo, ordered by name.
+func (r *repo) Tags() []*tag {
+	tags := make([]*tag, 0, len(r.tags))
+	for _, t := range r.tags {
+		tags = append(tags, t)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return strings.ToLower(tags[i].Name) < strings.ToLower(tags[j].Name)
+	})
+	return tags
+}
+
+// Tag returns the tag with the given name or slug.
+func (r *repo) Tag(name string) (*tag, bool) {
+	t, ok := r.tags[slugify(name)]
+	return t, ok
+}
+
+func (s *Site) serveTags(w http.ResponseWriter, r *http.Request) {
+	s.servePage(w, r, s.tagsPage(), "tags", s.activeRepo().Tags())
+}
+
+func (s *Site) serveTag(w http.ResponseWriter, r *http.Request, name string) {
+	t, ok := s.activeRepo().Tag(name)
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	s.servePage(w, r, s.tagPage(t), "tag", t)
+}
+
+func (s *Site) tagsPage() pageData {
+	data := s.newPage(nil)
+	data.PageTitle = "Tags"
+	data.Breadcrumbs = []pageLink{{Name: s.siteTitle(), URL: s.basePath + "/"}, {Name: "tags"}}
+	return data
+}
+
+func (s *Site) tagPage(t *tag) pageData {
+	data := s.newPage(nil)
+	data.PageTitle = "Tagged " + t.Name
+	data.Breadcrumbs = []pageLink{
+		{Name: s.siteTitle(), URL: s.basePath + "/"},
+		{Name: "tags", URL: s.basePath + "/tags"},
+		{Name: t.Name},
+	}
+	return data
+}
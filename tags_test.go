@@ -0,0 +1,69 @@
+package thoughts
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Go", "go"},
+		{"Machine Learning", "machine-learning"},
+		{"  c++ & rust ", "c-rust"},
+		{"café", "café"},
+		{"!!!", ""},
+	}
+	for _, tt := range tests {
+		if got := slugify(tt.in); got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSiteTags(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md":      "# Home",
+		"notes/a.md":     "---\ntags: [Go, Machine Learning]\n---\n# Alpha",
+		"notes/b.md":     "---\ntags: go, databases\n---\n# Beta",
+		"notes/c.md":     "---\ntags:\n  - machine learning\n---\n# Gamma",
+		"notes/plain.md": "# Untagged",
+	})
+
+	body := get(t, s, "/tags")
+	for _, want := range []string{
+		`<a href="/tags/databases">databases</a> <small>(1)</small>`,
+		`<a href="/tags/go">Go</a> <small>(2)</small>`,
+		`<a href="/tags/machine-learning">Machine Learning</a> <small>(2)</small>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in the tag listing, got %s", want, body)
+		}
+	}
+	if strings.Index(body, "databases") > strings.Index(body, ">Go<") {
+		t.Errorf("expected tags sorted by name, got %s", body)
+	}
+
+	body = get(t, s, "/tags/go")
+	for _, want := range []string{"<h1>Tagged Go</h1>", `<a href="/notes/a">Alpha</a>`, `<a href="/notes/b">Beta</a>`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s on the tag page, got %s", want, body)
+		}
+	}
+	if strings.Contains(body, "Gamma") || strings.Contains(body, "Untagged") {
+		t.Errorf("expected only notes tagged go, got %s", body)
+	}
+
+	if body := get(t, s, "/tags/GO"); !strings.Contains(body, "Tagged Go") {
+		t.Errorf("expected tag lookup to ignore case, got %s", body)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tags/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown tag, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,90 @@
+package thoughts
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// tocMarkerRE matches a paragraph asking for a table of contents.
+var tocMarkerRE = regexp.MustCompile(`(?i)^\s*(\[\[toc\]\]|\[toc\])\s*$`)
+
+// isTOCMarker reports whether node is a paragraph holding only a [[TOC]] or
+// [toc] marker.
+func isTOCMarker(node ast.Node) bool {
+	para, ok := node.(*ast.Paragraph)
+	if !ok || len(para.Children) != 1 {
+		return false
+	}
+	text, ok := para.Children[0].(*ast.Text)
+	return ok && tocMarkerRE.Match(text.Literal)
+}
+
+// transformTOC replaces each table of contents marker with a nested list
+// linking to the document's headings. It runs after the heading ids are
+// assigned, so the links match them.
+func transformTOC(doc ast.Node) {
+	var markers []ast.Node
+	var headings []*ast.Heading
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		if isTOCMarker(node) {
+			markers = append(markers, node)
+			return ast.SkipChildren
+		}
+		if h, ok := node.(*ast.Heading); ok && !h.IsTitleblock && h.HeadingID != "" {
+			headings = append(headings, h)
+			return ast.SkipChildren
+		}
+		return ast.GoToNext
+	})
+	if len(markers) == 0 {
+		return
+	}
+
+	toc := []byte(tocHTML(headings))
+	for _, marker := range markers {
+		block := &ast.HTMLBlock{Leaf: ast.Leaf{Literal: toc}}
+		parent := marker.GetParent()
+		children := parent.GetChildren()
+		for i, n := range children {
+			if n == marker {
+				children[i] = block
+			}
+		}
+		block.SetParent(parent)
+		parent.SetChildren(children)
+	}
+}
+
+// tocHTML nests headings into lists by level. A heading deeper than the one
+// before it starts a sublist, however many levels it skips.
+func tocHTML(headings []*ast.Heading) string {
+	var b strings.Builder
+	b.WriteString(`<div class="toc">`)
+
+	var levels []int
+	for _, h := range headings {
+		for len(levels) > 0 && h.Level < levels[len(levels)-1] {
+			b.WriteString("</li></ul>")
+			levels = levels[:len(levels)-1]
+		}
+		if len(levels) > 0 && h.Level == levels[len(levels)-1] {
+			b.WriteString("</li>")
+		} else {
+			b.WriteString("<ul>")
+			levels = append(levels, h.Level)
+		}
+		b.WriteString(`<li><a href="#` + html.EscapeString(h.HeadingID) + `">` + html.EscapeString(plainText(h)) + "</a>")
+	}
+	for range levels {
+		b.WriteString("</li></ul>")
+	}
+
+	b.WriteString("</div>")
+	return b.String()
+}
@@ -0,0 +1,50 @@
+package thoughts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderTOC(t *testing.T) {
+	markdown := "# Title\n\n[[TOC]]\n\n## Setup\n\n### Install\n\n## Setup\n\n#### Deep\n\n## Usage\n"
+
+	// The sanitizer marks every link nofollow, which is beside the point here.
+	render := func(markdown string) string {
+		return strings.ReplaceAll(renderString(t, markdown, RenderOptions{}), ` rel="nofollow"`, "")
+	}
+
+	out := render(markdown)
+	want := `<div class="toc"><ul><li><a href="#title">Title</a><ul><li><a href="#setup">Setup</a><ul><li><a href="#install">Install</a></li></ul></li><li><a href="#setup-2">Setup</a><ul><li><a href="#deep">Deep</a></li></ul></li><li><a href="#usage">Usage</a></li></ul></li></ul></div>`
+	if !strings.Contains(out, want) {
+		t.Errorf("expected %s in %s", want, out)
+	}
+	for _, id := range []string{`id="setup"`, `id="setup-2"`, `id="deep"`} {
+		if !strings.Contains(out, id) {
+			t.Errorf("expected the toc to link to heading %s, got %s", id, out)
+		}
+	}
+	if strings.Contains(out, "TOC") || strings.Contains(out, "wikilink-missing") {
+		t.Errorf("expected the marker to be replaced, got %s", out)
+	}
+
+	if out := render("## A\n\n[toc]\n"); !strings.Contains(out, `<div class="toc"><ul><li><a href="#a">A</a></li></ul></div>`) {
+		t.Errorf("expected [toc] to be replaced, got %s", out)
+	}
+
+	out = render("## A\n\nSee [toc] and `[[TOC]]` here.\n")
+	if strings.Contains(out, `class="toc"`) {
+		t.Errorf("expected no toc without a marker paragraph, got %s", out)
+	}
+	if !strings.Contains(out, "See [toc] and <code>[[TOC]]</code> here.") {
+		t.Errorf("expected the text to be unchanged, got %s", out)
+	}
+}
+
+func TestTOCMarkerIsNotABrokenLink(t *testing.T) {
+	r := newTestRepo(t, map[string]string{
+		"README.md": "# Hello\n\n[[TOC]]\n\n## Section\n",
+	})
+	if broken := r.BrokenLinks(); len(broken) != 0 {
+		t.Fatalf("expected no broken links, got %v", broken)
+	}
+}
@@ -0,0 +1,70 @@
+package thoughts
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// version and commit are set at build time, e.g.
+//
+//	go build -ldflags "-X github.com/josebalius/thoughts.version=v1.2.3 -X github.com/josebalius/thoughts.commit=abc123" ./cmd/thoughts
+//
+// When unset they fall back to what the Go toolchain embedded.
+var (
+	version = ""
+	commit  = ""
+)
+
+type versionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+	RepoHash  string `json:"repo_hash"`
+}
+
+func buildVersion() versionInfo {
+	info := versionInfo{
+		Version:   version,
+		Commit:    commit,
+		GoVersion: runtime.Version(),
+	}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	if info.Version == "" {
+		info.Version = bi.Main.Version
+	}
+	if info.Commit == "" {
+		for _, setting := range bi.Settings {
+			if setting.Key == "vcs.revision" {
+				info.Commit = setting.Value
+			}
+		}
+	}
+
+	return info
+}
+
+func (s *Site) serveVersion(w http.ResponseWriter, r *http.Request) {
+	info := buildVersion()
+	if s.activeRepo() != nil {
+		info.RepoHash = s.activeRepo().Hash()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		s.logger.Printf("failed to write version: %v\n", err)
+	}
+}
+
+// serveHealthz answers health checks with a bare ok, giving away nothing
+// about the site.
+func (s *Site) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,48 @@
+package thoughts
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSiteServeVersion(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md": "# Hello",
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/version", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected json, got %q", ct)
+	}
+
+	var got map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"version", "commit", "go_version", "repo_hash"} {
+		if _, ok := got[key]; !ok {
+			t.Errorf("expected %q in %v", key, got)
+		}
+	}
+	if got["repo_hash"] != "abc123" {
+		t.Errorf("expected the synced repo hash, got %q", got["repo_hash"])
+	}
+	if got["go_version"] == "" {
+		t.Error("expected a go version")
+	}
+}
+
+func TestSiteServeHealthz(t *testing.T) {
+	s := newTestSite(t, map[string]string{"README.md": "# Hello"})
+	if got := get(t, s, "/healthz"); got != "ok\n" {
+		t.Errorf("expected ok, got %q", got)
+	}
+}
@@ -0,0 +1,110 @@
+package thoughts
+
+import (
+	"html"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// wikiLinkRE matches [[target]] and [[target|label]].
+var wikiLinkRE = regexp.MustCompile(`\[\[([^\[\]|]+)(?:\|([^\[\]]+))?\]\]`)
+
+// wikiKey normalizes a wiki link target or a name it can resolve to.
+func wikiKey(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// buildWikiIndex maps the names wiki links can use to the documents they
+// resolve to: the served path, then the title, then the file name. When
+// documents share a name the one with the first path wins.
+func buildWikiIndex(docs []*document) map[string]*document {
+	sorted := append([]*document(nil), docs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].path < sorted[j].path
+	})
+
+	index := make(map[string]*document)
+	add := func(name func(*document) string) {
+		for _, d := range sorted {
+			k := wikiKey(name(d))
+			if _, exists := index[k]; !exists && k != "" {
+				index[k] = d
+			}
+		}
+	}
+	add((*document).servedPath)
+	add((*document).Title)
+	add(func(d *document) string { return path.Base(d.servedPath()) })
+
+	return index
+}
+
+// transformWikiLinks replaces [[target]] in text with links to the
+// documents in index, and marks targets that don't resolve as missing.
+func transformWikiLinks(doc ast.Node, index map[string]*document) {
+	var texts []*ast.Text
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if _, ok := node.(*ast.Link); ok {
+			return ast.SkipChildren
+		}
+		if text, ok := node.(*ast.Text); ok && entering && wikiLinkRE.Match(text.Literal) {
+			texts = append(texts, text)
+		}
+		return ast.GoToNext
+	})
+
+	for _, text := range texts {
+		parent := text.Parent
+		var nodes []ast.Node
+		for _, n := range parent.GetChildren() {
+			if n != text {
+				nodes = append(nodes, n)
+				continue
+			}
+			nodes = append(nodes, wikiLinkNodes(text.Literal, parent, index)...)
+		}
+		parent.SetChildren(nodes)
+	}
+}
+
+// wikiLinkNodes splits literal into text and the links it contains.
+func wikiLinkNodes(literal []byte, parent ast.Node, index map[string]*document) []ast.Node {
+	var nodes []ast.Node
+	addText := func(b []byte) {
+		if len(b) > 0 {
+			nodes = append(nodes, &ast.Text{Leaf: ast.Leaf{Literal: b, Parent: parent}})
+		}
+	}
+
+	last := 0
+	for _, m := range wikiLinkRE.FindAllSubmatchIndex(literal, -1) {
+		addText(literal[last:m[0]])
+		last = m[1]
+
+		target := string(literal[m[2]:m[3]])
+		label := target
+		if m[4] >= 0 {
+			label = string(literal[m[4]:m[5]])
+		}
+		label = strings.TrimSpace(label)
+
+		d, ok := index[wikiKey(target)]
+		if !ok {
+			span := `<span class="wikilink-missing">` + html.EscapeString(label) + `</span>`
+			nodes = append(nodes, &ast.HTMLSpan{Leaf: ast.Leaf{Literal: []byte(span), Parent: parent}})
+			continue
+		}
+
+		link := &ast.Link{Destination: []byte(d.URL())}
+		link.Parent = parent
+		link.Children = []ast.Node{&ast.Text{Leaf: ast.Leaf{Literal: []byte(label), Parent: link}}}
+		nodes = append(nodes, link)
+	}
+	addText(literal[last:])
+
+	return nodes
+}
@@ -0,0 +1,37 @@
+package thoughts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSiteWikiLinks(t *testing.T) {
+	s := newTestSite(t, map[string]string{
+		"README.md":               "# Home",
+		"notes/some-note.md":      "# Some Note\n\nBody.",
+		"notes/2024-01-02-log.md": "# Log",
+		"links.md": strings.Join([]string{
+			"By title [[Some Note]], by name [[log]], by path [[notes/some-note|the note]].",
+			"Case [[some   NOTE]] and home [[Home]].",
+			"Missing [[Nowhere & Else]].",
+			"Code `[[Some Note]]` stays.",
+			"```sh\n[[ -f notes ]]\n```",
+		}, "\n\n"),
+	})
+
+	body := get(t, s, "/links")
+	for _, want := range []string{
+		`<a href="/notes/some-note" rel="nofollow">Some Note</a>`,
+		`<a href="/notes/2024-01-02-log" rel="nofollow">log</a>`,
+		`<a href="/notes/some-note" rel="nofollow">the note</a>`,
+		`<a href="/notes/some-note" rel="nofollow">some   NOTE</a>`,
+		`<a href="/" rel="nofollow">Home</a>`,
+		`<span class="wikilink-missing">Nowhere &amp; Else</span>`,
+		"<code>[[Some Note]]</code>",
+		"[[ -f notes ]]",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in %s", want, body)
+		}
+	}
+}